@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+// Name of the state file in the output directory, unless --state is given
+const defaultBatchState = "batch-state.jsonl"
+
+// Settings shared by the conversions of a batch
+type batch struct {
+	inDir      string
+	outDir     string
+	password   string
+	opts       converter.ConvertOptions
+	optionsMAC string // See batchOptionsMAC
+	timeout    time.Duration
+	state      *batchState
+	logger     *slog.Logger
+}
+
+// A file of the batch, with paths relative to the input and output directories
+type batchFile struct {
+	input  string
+	output string
+}
+
+// Converts every file in a directory, recording the outcome of each in a state file so that
+// an interrupted or crashed batch can be resumed without redoing finished files
+func runBatch(ctx context.Context, cmd *command, args []string) error {
+	fs := cmd.newFlagSet()
+	statePath := fs.String("state", "", "Path to the state file (default <output dir>/"+defaultBatchState+")")
+	retryFailed := fs.Bool("retry-failed", false, "Only retry the files that failed in a previous run")
+	password := fs.String("password", "", "Password of the encrypted legacy backups")
+	optionsJSON := fs.String("options", "", `Conversion options as JSON, named like those of worker (e.g. {"codec": "zstd"})`)
+	jobs := fs.Int("jobs", runtime.NumCPU(), "Number of files converted at the same time")
+	timeout := fs.Duration("timeout", 0, "Abort (and fail) the conversion of a file taking longer than this (0 for no timeout)")
+	var log logFlags
+	log.register(fs)
+
+	err := cmd.parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	logger, err := log.logger(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if *jobs < 1 {
+		return usageError("--jobs must be at least 1")
+	}
+
+	var reqOpts conversionOptions
+	if *optionsJSON != "" {
+		dec := json.NewDecoder(strings.NewReader(*optionsJSON))
+		dec.DisallowUnknownFields()
+
+		err = dec.Decode(&reqOpts)
+		if err != nil {
+			return usageError("invalid --options: " + err.Error())
+		}
+	}
+
+	opts, err := reqOpts.convertOptions()
+	if err != nil {
+		return err
+	}
+
+	// The state records a hash per output file
+	if opts.Format == converter.OutputDir {
+		return usageError("the dir output format is not supported in batches")
+	}
+
+	b := &batch{
+		inDir:    fs.Arg(0),
+		outDir:   fs.Arg(1),
+		password: *password,
+		opts:     opts,
+		timeout:  *timeout,
+		logger:   logger,
+	}
+
+	if *statePath == "" {
+		*statePath = filepath.Join(b.outDir, defaultBatchState)
+	}
+
+	err = os.MkdirAll(b.outDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	b.state, err = openBatchState(*statePath)
+	if err != nil {
+		return err
+	}
+	defer b.state.Close()
+
+	b.optionsMAC = batchOptionsMAC(b.state.key, reqOpts, opts, *password)
+
+	files, err := b.files(*statePath)
+	if err != nil {
+		return err
+	}
+
+	if *retryFailed {
+		var failed []batchFile
+		for _, file := range files {
+			if entry, ok := b.state.get(file.input); ok && entry.Status == batchFailed {
+				failed = append(failed, file)
+			}
+		}
+		files = failed
+	}
+
+	return b.run(ctx, files, *jobs)
+}
+
+// Lists the files of the input directory along with their output paths, leaving out the
+// state file and output directory should they be inside the input directory
+func (b *batch) files(statePath string) ([]batchFile, error) {
+	skip := make(map[string]bool)
+	for _, path := range []string{statePath, statePath + batchKeySuffix, b.outDir} {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		skip[abs] = true
+	}
+
+	ext := ".arb1"
+	if b.opts.EncryptionPassword != "" {
+		ext = ".arb1e"
+	}
+
+	var files []batchFile
+	var inputs = make(map[string]string) // Output to input
+	err := filepath.WalkDir(b.inDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+
+		if skip[abs] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.inDir, path)
+		if err != nil {
+			return err
+		}
+
+		output := strings.TrimSuffix(rel, filepath.Ext(rel)) + ext
+		if other, ok := inputs[output]; ok {
+			return fmt.Errorf("%s and %s would both be converted to %s", other, rel, output)
+		}
+		inputs[output] = rel
+
+		files = append(files, batchFile{input: filepath.ToSlash(rel), output: output})
+		return nil
+	})
+
+	return files, err
+}
+
+// Converts the files with the given number of workers, stopping once ctx is done or the state
+// cannot be written
+func (b *batch) run(ctx context.Context, files []batchFile, jobs int) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan batchFile)
+	go func() {
+		defer close(queue)
+		for _, file := range files {
+			select {
+			case queue <- file:
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var converted, skipped, failed int
+	var fatal error
+
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for file := range queue {
+				status, err := b.convert(runCtx, file)
+
+				mu.Lock()
+				if err != nil && fatal == nil {
+					fatal = err
+					cancel()
+				}
+
+				switch status {
+				case batchDone:
+					converted++
+				case batchFailed:
+					failed++
+				case batchSkipped:
+					skipped++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	b.logger.Info("batch finished", "converted", converted, "skipped", skipped, "failed", failed)
+
+	if fatal != nil {
+		return fatal
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", converter.ErrCanceled, context.Cause(ctx))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to convert, rerun with --retry-failed to retry only those", failed, len(files))
+	}
+
+	return nil
+}
+
+// Converts a file unless it was converted before with the same options and neither it nor its output changed since,
+// returning the recorded status, batchSkipped or an empty status if interrupted. Only errors writing the state
+// are returned, as failed conversions are recorded instead
+func (b *batch) convert(ctx context.Context, file batchFile) (batchStatus, error) {
+	logger := b.logger.With("input", file.input)
+
+	if ctx.Err() != nil {
+		return "", nil
+	}
+
+	outputPath := filepath.Join(b.outDir, file.output)
+	entry := batchEntry{Input: file.input, OptionsMAC: b.optionsMAC, Output: filepath.ToSlash(file.output)}
+
+	data, err := os.ReadFile(filepath.Join(b.inDir, filepath.FromSlash(file.input)))
+	if err != nil {
+		return b.fail(logger, entry, err)
+	}
+
+	entry.InputSHA256 = sha256Hex(data)
+
+	if prev, ok := b.state.get(file.input); ok && prev.Status == batchDone && prev.InputSHA256 == entry.InputSHA256 &&
+		prev.OptionsMAC == entry.OptionsMAC && prev.Output == entry.Output {
+		output, err := os.ReadFile(outputPath)
+		if err == nil && sha256Hex(output) == prev.OutputSHA256 {
+			logger.Debug("skipped unchanged file", "output", outputPath)
+			return batchSkipped, nil
+		}
+	}
+
+	// Steps of a timed out conversion still running in the background are waited for, so that no
+	// more than the given number of conversions run at once
+	convertCtx, wait := converter.WithBackgroundWait(ctx)
+	defer wait()
+
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		convertCtx, cancel = context.WithTimeout(convertCtx, b.timeout)
+		defer cancel()
+	}
+
+	opts := b.opts
+	opts.Logger = logger
+
+	res, err := converter.ConvertFileContext(convertCtx, data, b.password, opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Interrupted rather than failed, so the file is converted again on the next run
+			return "", nil
+		}
+
+		return b.fail(logger, entry, err)
+	}
+
+	logWarnings(logger, res.DanglingReferences, res.AllocationIssues)
+	logIntegrityIssues(logger, res.IntegrityIssues)
+
+	err = writeFileAtomic(outputPath, res.Data)
+	if err != nil {
+		return b.fail(logger, entry, err)
+	}
+
+	entry.OutputSHA256 = sha256Hex(res.Data)
+	entry.Status = batchDone
+	entry.UpdatedAt = time.Now()
+
+	err = b.state.put(entry)
+	if err != nil {
+		return "", err
+	}
+
+	logger.Info("converted", "output", outputPath)
+	return batchDone, nil
+}
+
+// Records a failed conversion
+func (b *batch) fail(logger *slog.Logger, entry batchEntry, err error) (batchStatus, error) {
+	entry.Status = batchFailed
+	entry.Error = err.Error()
+	entry.ErrorClass = errorClass(err)
+	entry.UpdatedAt = time.Now()
+
+	logger.Warn("failed", "error", err, "class", entry.ErrorClass)
+
+	err = b.state.put(entry)
+	if err != nil {
+		return "", err
+	}
+
+	return batchFailed, nil
+}
+
+// Writes a file through a temporary file in the same directory, so that it is never seen half written
+func writeFileAtomic(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Returns an HMAC of the effective options and password of a batch, so that files converted
+// with other options are not skipped. Codec and format defaults are resolved first so that
+// leaving them out and naming them give the same MAC
+//
+// The options include the encryption password, so a plain hash would let anyone reading the
+// state file test password guesses against it. The key is kept next to the state file instead
+func batchOptionsMAC(key []byte, reqOpts conversionOptions, opts converter.ConvertOptions, password string) string {
+	reqOpts.Codec = string(opts.Codec)
+	reqOpts.Format = string(opts.Format)
+
+	data, _ := json.Marshal(reqOpts) // Only holds strings, numbers and booleans
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(append(append(data, 0), password...))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
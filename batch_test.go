@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anti-raid/legacybackupconverter/internal/legacytest"
+)
+
+func TestBatchSkipsOnlyUnchangedConversions(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+
+	err := os.WriteFile(filepath.Join(in, "backup.iblfile"), legacytest.Backup(t, 5, "hunter2"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		skipped bool
+	}{
+		{"first run", []string{"--password", "hunter2"}, false},
+		{"unchanged", []string{"--password", "hunter2"}, true},
+		{"default codec named", []string{"--password", "hunter2", "--options", `{"codec": "gzip"}`}, true},
+		{"options changed", []string{"--password", "hunter2", "--options", `{"codec": "zstd"}`}, false},
+		{"password changed", []string{"--password", "wrong", "--options", `{"codec": "zstd"}`}, false},
+		{"failure retried", []string{"--password", "hunter2", "--options", `{"codec": "zstd"}`}, false},
+	}
+
+	var prev batchEntry
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runBatch(t.Context(), findCommand("batch"), append(test.args, "-q", in, out))
+
+			state, err := openBatchState(filepath.Join(out, defaultBatchState))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer state.Close()
+
+			entry, ok := state.get("backup.iblfile")
+			if !ok {
+				t.Fatal("no state entry for backup.iblfile")
+			}
+
+			if skipped := entry == prev; skipped != test.skipped {
+				t.Fatalf("expected skipped = %v, got entry %+v after %+v", test.skipped, entry, prev)
+			}
+
+			prev = entry
+		})
+	}
+}
+
+func TestBatchStateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultBatchState)
+
+	state, err := openBatchState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Close()
+
+	info, err := os.Stat(path + batchKeySuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 || info.Size() != batchKeySize {
+		t.Fatalf("expected a %d byte key readable only by its owner, got %s (%d bytes)", batchKeySize, info.Mode(), info.Size())
+	}
+
+	reopened, err := openBatchState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+
+	if !bytes.Equal(reopened.key, state.key) {
+		t.Fatal("expected the key to be kept across runs")
+	}
+
+	opts := conversionOptions{EncryptPassword: "secret"}
+	convertOpts, err := opts.convertOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if batchOptionsMAC(state.key, opts, convertOpts, "hunter2") == batchOptionsMAC(make([]byte, batchKeySize), opts, convertOpts, "hunter2") {
+		t.Fatal("expected the MAC to depend on the key")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+type batchStatus string
+
+const (
+	batchDone   batchStatus = "done"
+	batchFailed batchStatus = "failed"
+
+	// Never recorded, returned for files that were converted before and left as they are
+	batchSkipped batchStatus = "skipped"
+)
+
+// The outcome of converting one file of a batch
+type batchEntry struct {
+	Input        string      `json:"input"` // Relative to the input directory
+	InputSHA256  string      `json:"input_sha256"`
+	OptionsMAC   string      `json:"options_mac"`      // See batchOptionsMAC
+	Output       string      `json:"output,omitempty"` // Relative to the output directory
+	OutputSHA256 string      `json:"output_sha256,omitempty"`
+	Status       batchStatus `json:"status"`
+	Error        string      `json:"error,omitempty"`
+	ErrorClass   string      `json:"error_class,omitempty"` // See errorClass
+	UpdatedAt    time.Time   `json:"updated_at"`
+}
+
+// The state of a batch, kept as one JSON entry per line
+//
+// Entries are appended (and synced) as files finish, so a crash loses at most the entry being
+// written, which is ignored on the next load. The last entry of each input wins, and loading
+// compacts the file down to those entries
+type batchState struct {
+	mu      sync.Mutex
+	f       *os.File
+	entries map[string]batchEntry
+	key     []byte // Key of the options MACs, see loadBatchKey
+}
+
+// Appended to the path of a state file to get the path of its key
+const batchKeySuffix = ".key"
+
+// Size of the key of a state file in bytes
+const batchKeySize = 32
+
+// Loads (or creates) the state file at path and opens it for appending
+func openBatchState(path string) (*batchState, error) {
+	s := &batchState{entries: make(map[string]batchEntry)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var entry batchEntry
+		err := json.Unmarshal(line, &entry)
+		if err != nil {
+			if i == len(lines)-1 {
+				break // Cut short by a crash while appending
+			}
+
+			return nil, fmt.Errorf("failed to read state file %s (line %d): %w", path, i+1, err)
+		}
+
+		s.entries[entry.Input] = entry
+	}
+
+	s.key, err = loadBatchKey(path + batchKeySuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.compact(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Loads the random key of a state file, creating it (readable only by its owner) if missing
+//
+// Entries recorded under another key no longer match, so losing the key only redoes conversions
+func loadBatchKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != batchKeySize {
+			return nil, fmt.Errorf("invalid state key %s: expected %d bytes, got %d", path, batchKeySize, len(key))
+		}
+
+		return key, nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	key = make([]byte, batchKeySize)
+	rand.Read(key)
+
+	err = os.WriteFile(path, key, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Rewrites the state file with the latest entry of each input, replacing it atomically
+func (s *batchState) compact(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, entry := range s.sorted() {
+		err = enc.Encode(entry)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Returns the entries ordered by input
+func (s *batchState) sorted() []batchEntry {
+	var entries []batchEntry
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a, b batchEntry) int {
+		return cmp.Compare(a.Input, b.Input)
+	})
+
+	return entries
+}
+
+// Returns the latest entry of an input, if any
+func (s *batchState) get(input string) (batchEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[input]
+	return entry, ok
+}
+
+// Records an entry, appending it to the state file before returning
+func (s *batchState) put(entry batchEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	err = s.f.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	s.entries[entry.Input] = entry
+	return nil
+}
+
+func (s *batchState) Close() error {
+	return s.f.Close()
+}
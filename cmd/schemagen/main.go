@@ -0,0 +1,48 @@
+// Command schemagen writes the JSON Schema for core.json.gz generated from converter.CoreBackupData
+//
+// With -check, it instead exits with a non-zero status if the file on disk is out of date
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+func main() {
+	output := flag.String("o", "schema/core.schema.json", "Path to write the schema to")
+	check := flag.Bool("check", false, "Check that the schema at -o is up to date instead of writing it")
+	flag.Parse()
+
+	schema, err := json.MarshalIndent(converter.CoreBackupDataSchema(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate schema: %s\n", err)
+		os.Exit(1)
+	}
+	schema = append(schema, '\n')
+
+	if *check {
+		current, err := os.ReadFile(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %s\n", *output, err)
+			os.Exit(1)
+		}
+
+		if !bytes.Equal(current, schema) {
+			fmt.Fprintf(os.Stderr, "%s is out of date, run go generate ./...\n", *output)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	err = os.WriteFile(*output, schema, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %s\n", *output, err)
+		os.Exit(1)
+	}
+}
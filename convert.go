@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+// Converts a legacy backup to the ARB1 format, printing any warnings to stderr
+func runConvert(ctx context.Context, cmd *command, args []string) error {
+	fs := cmd.newFlagSet()
+	signKeyPath := fs.String("sign-key", "", "Path to a PEM encoded Ed25519 private key to sign the output with")
+	sigOutPath := fs.String("sig-out", "", "Path to also write the detached signature to (requires --sign-key)")
+	enforceAllocations := fs.Bool("enforce-allocations", false, "Prune messages exceeding the allocation implied by the backup options")
+	pruneDanglingOptions := fs.Bool("prune-dangling-options", false, "Remove channel IDs that do not exist in the backup from the channels and specialAllocations options")
+	keepAttachments := fs.Bool("keep-attachments", false, "Keep attachment metadata on messages and store attachment files found in the legacy backup")
+	dedupAuthors := fs.Bool("dedup-authors", false, "Hoist message authors into a users table to shrink core.json.gz")
+	splitMessages := fs.Bool("split-messages", false, "Store each channel's messages in its own messages/<channel id>.json.gz entry")
+	codecName := fs.String("codec", "gzip", "Compression codec for JSON entries (gzip, zstd or none)")
+	level := fs.Int("level", 0, "Compression level for the codec (0 for the codec's default)")
+	formatName := fs.String("format", "tar", "Output format (tar, zip or dir to unpack the backup into a directory)")
+	skipMessages := fs.Bool("skip-messages", false, "Leave out all messages")
+	skipAssets := fs.Bool("skip-assets", false, "Leave out the guild icon, banner and splash")
+	includeChannels := fs.String("channels", "", "Comma separated channel IDs to keep, leaving out all other channels")
+	excludeChannels := fs.String("exclude-channels", "", "Comma separated channel IDs to leave out")
+	maxMessagesPerChannel := fs.Int("max-messages-per-channel", 0, "Keep at most this many of the newest messages per channel (0 for no limit)")
+	maxInputSize := fs.Int64("max-input-size", 0, "Refuse legacy backups larger than this many bytes (0 for no limit)")
+	maxOutputSize := fs.Int64("max-output-size", 0, "Refuse conversions whose entries add up to more than this many bytes (0 for no limit)")
+	encryptPassword := fs.String("encrypt-password", "", "Encrypt the output with this password (an .arb1e backup). Visible to other users in the process list, prefer --encrypt-password-file")
+	encryptPasswordFile := fs.String("encrypt-password-file", "", "Encrypt the output with the password read from this file (or - for stdin)")
+	timeout := fs.Duration("timeout", 0, "Abort the conversion if it takes longer than this (e.g. 30s, 0 for no timeout)")
+	showProgress := fs.Bool("progress", isTerminal(os.Stderr), "Show a progress bar on stderr (on by default when stderr is a terminal)")
+	reproducible := fs.Bool("reproducible", false, "Omit the conversion time from the manifest so that the output is byte-for-byte reproducible")
+	var log logFlags
+	log.register(fs)
+
+	err := cmd.parse(fs, args, 2, 3)
+	if err != nil {
+		return err
+	}
+
+	logger, err := log.logger(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	legacyBackupPath := fs.Arg(0)
+	outputPath := fs.Arg(1)
+	password := fs.Arg(2)
+
+	codec, err := converter.ParseCodec(*codecName)
+	if err != nil {
+		return usageError(err.Error())
+	}
+
+	err = codec.ValidateLevel(*level)
+	if err != nil {
+		return usageError(err.Error())
+	}
+
+	format, err := converter.ParseOutputFormat(*formatName)
+	if err != nil {
+		return usageError(err.Error())
+	}
+
+	if format == converter.OutputDir && outputPath == "-" {
+		return usageError("the dir output format cannot be written to stdout")
+	}
+
+	if *sigOutPath != "" && *signKeyPath == "" {
+		return usageError("--sig-out requires --sign-key")
+	}
+
+	if *sigOutPath == "-" && outputPath == "-" {
+		return usageError("--sig-out and the output cannot both be written to stdout")
+	}
+
+	if *encryptPassword != "" && *encryptPasswordFile != "" {
+		return usageError("--encrypt-password and --encrypt-password-file cannot be combined")
+	}
+
+	if *encryptPasswordFile == "-" && legacyBackupPath == "-" {
+		return usageError("--encrypt-password-file and the legacy backup cannot both be read from stdin")
+	}
+
+	if format == converter.OutputDir && (*encryptPassword != "" || *encryptPasswordFile != "") {
+		return usageError("--encrypt-password is not supported with the dir output format")
+	}
+
+	if *encryptPassword != "" {
+		logger.Warn("--encrypt-password is visible to other users in the process list, prefer --encrypt-password-file")
+	}
+
+	if *encryptPasswordFile != "" {
+		*encryptPassword, err = readPasswordFile(*encryptPasswordFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	var key ed25519.PrivateKey
+	if *signKeyPath != "" {
+		keyBytes, err := os.ReadFile(*signKeyPath)
+		if err != nil {
+			return err
+		}
+
+		key, err = converter.ParseSigningKey(keyBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	fileBytes, err := readInput(legacyBackupPath)
+	if err != nil {
+		return err
+	}
+
+	opts := converter.ConvertOptions{
+		EnforceAllocations:    *enforceAllocations,
+		PruneDanglingOptions:  *pruneDanglingOptions,
+		KeepAttachments:       *keepAttachments,
+		DedupAuthors:          *dedupAuthors,
+		SplitMessages:         *splitMessages,
+		Codec:                 codec,
+		CompressionLevel:      *level,
+		Format:                format,
+		SkipMessages:          *skipMessages,
+		SkipAssets:            *skipAssets,
+		IncludeChannels:       splitList(*includeChannels),
+		ExcludeChannels:       splitList(*excludeChannels),
+		MaxMessagesPerChannel: *maxMessagesPerChannel,
+		MaxInputSize:          *maxInputSize,
+		MaxOutputSize:         *maxOutputSize,
+		EncryptionPassword:    *encryptPassword,
+		SigningKey:            key,
+		Logger:                logger,
+	}
+	if !*reproducible {
+		opts.ConvertedAt = time.Now()
+	}
+	if format == converter.OutputDir {
+		opts.OutputDir = outputPath
+	}
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	bar := &progressBar{w: os.Stderr}
+	if *showProgress && !log.quiet {
+		opts.Progress = bar.update
+	}
+
+	res, err := converter.ConvertFileContext(ctx, fileBytes, password, opts)
+	bar.finish()
+	if err != nil {
+		return err
+	}
+
+	logWarnings(logger, res.DanglingReferences, res.AllocationIssues)
+	logIntegrityIssues(logger, res.IntegrityIssues)
+
+	if res.DuplicateMessages > 0 {
+		logger.Info("removed duplicate messages", "count", res.DuplicateMessages)
+	}
+
+	if res.PrunedMessages > 0 {
+		logger.Info("pruned messages exceeding their allocation", "count", res.PrunedMessages)
+	}
+
+	if res.TruncatedMessages > 0 {
+		logger.Info("left out old messages exceeding --max-messages-per-channel", "count", res.TruncatedMessages)
+	}
+
+	if res.DedupedAuthors > 0 {
+		logger.Info("replaced message authors with references", "count", res.DedupedAuthors, "core_size", res.CoreSize)
+	}
+
+	if *sigOutPath != "" {
+		err = writeOutput(*sigOutPath, res.Signature)
+		if err != nil {
+			return err
+		}
+	}
+
+	if format == converter.OutputDir {
+		return nil
+	}
+
+	return writeOutput(outputPath, res.Data)
+}
+
+// Reads a password from the first line of a file, or stdin if path is -
+func readPasswordFile(path string) (string, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return "", err
+	}
+
+	password, _, _ := strings.Cut(string(data), "\n")
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", usageError("the password file " + path + " is empty")
+	}
+	return password, nil
+}
+
+// Splits a comma separated list, ignoring empty elements
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,238 @@
+package converter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// The kind of inconsistency between a backup's messages and its options
+type AllocationIssueKind string
+
+const (
+	// A channel holds more messages than its allocation (after rollover) allows
+	AllocationIssueOverAllocation AllocationIssueKind = "over_allocation"
+	// A channel holds messages but was not selected for backup in the options
+	AllocationIssueNotSelected AllocationIssueKind = "not_selected"
+	// Messages are present although the options did not enable message backups
+	AllocationIssueMessagesDisabled AllocationIssueKind = "messages_disabled"
+	// The recorded channel allocation does not match the number of messages present
+	AllocationIssueMismatch AllocationIssueKind = "allocation_mismatch"
+	// The backup holds more messages in total than MaxMessages
+	AllocationIssueOverMaxMessages AllocationIssueKind = "over_max_messages"
+)
+
+// An inconsistency between a backup's messages and the allocation implied by its options
+type AllocationIssue struct {
+	Kind      AllocationIssueKind `json:"kind"`
+	ChannelID string              `json:"channel_id,omitempty"` // Empty for backup-wide issues
+	Expected  int                 `json:"expected"`
+	Actual    int                 `json:"actual"`
+}
+
+func (i AllocationIssue) String() string {
+	if i.ChannelID == "" {
+		return fmt.Sprintf("%s: expected at most %d messages, found %d", i.Kind, i.Expected, i.Actual)
+	}
+
+	return fmt.Sprintf("%s: channel %s expected %d messages, found %d", i.Kind, i.ChannelID, i.Expected, i.Actual)
+}
+
+// Computes the number of messages each channel may hold under the backup options
+//
+// This mirrors the legacy backup job: selected channels (all channels if opts.Channels
+// is empty) get their SpecialAllocations entry or PerChannel messages, in channel order,
+// until MaxMessages is used up. With RolloverLeftovers, allocation left unused by channels
+// with fewer messages than allowed is handed out in channel order to channels with more.
+//
+// counts holds the number of messages actually present per channel and is only used for rollover.
+// Channels that may not hold any messages are omitted from the result
+func ComputeAllocations(opts BackupCreateOpts, channels []discordgo.Channel, counts map[string]int) map[string]int {
+	allocations := make(map[string]int)
+
+	if !opts.BackupMessages {
+		return allocations
+	}
+
+	var selected []string
+	for _, channel := range channels {
+		if len(opts.Channels) == 0 || slices.Contains(opts.Channels, channel.ID) {
+			selected = append(selected, channel.ID)
+		}
+	}
+
+	var used int
+	for _, id := range selected {
+		alloc := opts.PerChannel
+		if special, ok := opts.SpecialAllocations[id]; ok {
+			alloc = special
+		}
+
+		if opts.MaxMessages > 0 {
+			alloc = min(alloc, opts.MaxMessages-used)
+		}
+
+		if alloc <= 0 {
+			continue
+		}
+
+		allocations[id] = alloc
+		used += alloc
+	}
+
+	if opts.Legacy == nil || !opts.Legacy.RolloverLeftovers {
+		return allocations
+	}
+
+	var leftover int
+	for _, id := range selected {
+		if counts[id] < allocations[id] {
+			leftover += allocations[id] - counts[id]
+		}
+	}
+
+	for _, id := range selected {
+		if leftover <= 0 {
+			break
+		}
+
+		if _, ok := allocations[id]; !ok {
+			// Channels without any allocation were never backed up and hence cannot receive leftovers
+			continue
+		}
+
+		if extra := min(counts[id]-allocations[id], leftover); extra > 0 {
+			allocations[id] += extra
+			leftover -= extra
+		}
+	}
+
+	return allocations
+}
+
+// Compares the messages in a backup with the allocation implied by its options
+func CheckAllocations(core *CoreBackupData) []AllocationIssue {
+	counts := messageCounts(core)
+	allocations := ComputeAllocations(core.Options, core.Channels, counts)
+
+	var issues []AllocationIssue
+	var total int
+	for _, id := range sortedKeys(counts) {
+		count := counts[id]
+		total += count
+
+		if core.ChannelAllocation[id] != count {
+			issues = append(issues, AllocationIssue{Kind: AllocationIssueMismatch, ChannelID: id, Expected: count, Actual: core.ChannelAllocation[id]})
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		alloc, ok := allocations[id]
+
+		switch {
+		case !core.Options.BackupMessages:
+			issues = append(issues, AllocationIssue{Kind: AllocationIssueMessagesDisabled, ChannelID: id, Expected: 0, Actual: count})
+		case !ok:
+			issues = append(issues, AllocationIssue{Kind: AllocationIssueNotSelected, ChannelID: id, Expected: 0, Actual: count})
+		case count > alloc:
+			issues = append(issues, AllocationIssue{Kind: AllocationIssueOverAllocation, ChannelID: id, Expected: alloc, Actual: count})
+		}
+	}
+
+	for _, id := range sortedKeys(core.ChannelAllocation) {
+		if _, ok := counts[id]; !ok && core.ChannelAllocation[id] != 0 {
+			issues = append(issues, AllocationIssue{Kind: AllocationIssueMismatch, ChannelID: id, Expected: 0, Actual: core.ChannelAllocation[id]})
+		}
+	}
+
+	if core.Options.MaxMessages > 0 && total > core.Options.MaxMessages {
+		issues = append(issues, AllocationIssue{Kind: AllocationIssueOverMaxMessages, Expected: core.Options.MaxMessages, Actual: total})
+	}
+
+	return issues
+}
+
+// Prunes messages exceeding the allocation implied by the backup options, returning the number of messages removed
+//
+// The newest messages of each channel are kept, matching the order the legacy backup job fetched them in.
+// ChannelAllocation is recomputed from the remaining messages
+func EnforceAllocations(core *CoreBackupData) int {
+	allocations := ComputeAllocations(core.Options, core.Channels, messageCounts(core))
+
+	var pruned int
+	for id, messages := range core.Messages {
+		alloc := allocations[id]
+
+		if len(messages) <= alloc {
+			continue
+		}
+
+		if alloc == 0 {
+			pruned += len(messages)
+			delete(core.Messages, id)
+			continue
+		}
+
+		// Keep the newest (highest snowflake) messages, preserving their original order
+		newest := slices.Clone(messages)
+		slices.SortStableFunc(newest, func(a, b discordgo.Message) int {
+			return compareSnowflakes(b.ID, a.ID)
+		})
+
+		keep := make(map[string]bool, alloc)
+		for _, msg := range newest {
+			if len(keep) >= alloc {
+				break
+			}
+			keep[msg.ID] = true
+		}
+
+		kept := slices.DeleteFunc(messages, func(msg discordgo.Message) bool {
+			if !keep[msg.ID] {
+				return true
+			}
+			delete(keep, msg.ID) // Only keep the first copy of duplicated IDs
+			return false
+		})
+
+		pruned += len(messages) - len(kept)
+		core.Messages[id] = kept
+	}
+
+	core.ChannelAllocation = make(map[string]int, len(core.Messages))
+	for id, messages := range core.Messages {
+		core.ChannelAllocation[id] = len(messages)
+	}
+
+	return pruned
+}
+
+func messageCounts(core *CoreBackupData) map[string]int {
+	counts := make(map[string]int, len(core.Messages))
+	for id, messages := range core.Messages {
+		counts[id] = len(messages)
+	}
+	return counts
+}
+
+// Compares two snowflake IDs numerically without parsing them
+func compareSnowflakes(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+
+	return strings.Compare(a, b)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
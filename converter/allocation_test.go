@@ -0,0 +1,218 @@
+package converter
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func testChannels(ids ...string) []discordgo.Channel {
+	var channels []discordgo.Channel
+	for _, id := range ids {
+		channels = append(channels, discordgo.Channel{ID: id})
+	}
+	return channels
+}
+
+func testMessages(channelID string, ids ...string) []discordgo.Message {
+	var messages []discordgo.Message
+	for _, id := range ids {
+		messages = append(messages, discordgo.Message{ID: id, ChannelID: channelID})
+	}
+	return messages
+}
+
+func TestComputeAllocations(t *testing.T) {
+	rollover := &LegacyBackupCreateOpts{RolloverLeftovers: true}
+
+	tests := []struct {
+		name   string
+		opts   BackupCreateOpts
+		counts map[string]int
+		want   map[string]int
+	}{
+		{
+			name: "messages disabled",
+			opts: BackupCreateOpts{PerChannel: 5},
+			want: map[string]int{},
+		},
+		{
+			name: "per channel",
+			opts: BackupCreateOpts{BackupMessages: true, PerChannel: 5},
+			want: map[string]int{"1": 5, "2": 5, "3": 5},
+		},
+		{
+			name: "selected channels",
+			opts: BackupCreateOpts{BackupMessages: true, PerChannel: 5, Channels: []string{"1", "3"}},
+			want: map[string]int{"1": 5, "3": 5},
+		},
+		{
+			name: "special allocation",
+			opts: BackupCreateOpts{BackupMessages: true, PerChannel: 5, SpecialAllocations: map[string]int{"2": 10}},
+			want: map[string]int{"1": 5, "2": 10, "3": 5},
+		},
+		{
+			name: "max messages cuts the last channel short",
+			opts: BackupCreateOpts{BackupMessages: true, PerChannel: 5, MaxMessages: 12},
+			want: map[string]int{"1": 5, "2": 5, "3": 2},
+		},
+		{
+			name: "max messages leaves the last channel out",
+			opts: BackupCreateOpts{BackupMessages: true, PerChannel: 5, MaxMessages: 10},
+			want: map[string]int{"1": 5, "2": 5},
+		},
+		{
+			name:   "without rollover",
+			opts:   BackupCreateOpts{BackupMessages: true, PerChannel: 5},
+			counts: map[string]int{"1": 2, "2": 9, "3": 8},
+			want:   map[string]int{"1": 5, "2": 5, "3": 5},
+		},
+		{
+			name:   "rollover in channel order",
+			opts:   BackupCreateOpts{BackupMessages: true, PerChannel: 5, Legacy: rollover},
+			counts: map[string]int{"1": 2, "2": 9, "3": 8},
+			want:   map[string]int{"1": 5, "2": 8, "3": 5},
+		},
+		{
+			name:   "rollover spread over channels",
+			opts:   BackupCreateOpts{BackupMessages: true, PerChannel: 5, Legacy: rollover},
+			counts: map[string]int{"1": 0, "2": 7, "3": 9},
+			want:   map[string]int{"1": 5, "2": 7, "3": 8},
+		},
+		{
+			name:   "rollover skips channels without allocation",
+			opts:   BackupCreateOpts{BackupMessages: true, PerChannel: 5, MaxMessages: 10, Legacy: rollover},
+			counts: map[string]int{"1": 1, "2": 9, "3": 9},
+			want:   map[string]int{"1": 5, "2": 9},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ComputeAllocations(test.opts, testChannels("1", "2", "3"), test.counts)
+			if !maps.Equal(got, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestEnforceAllocations(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     BackupCreateOpts
+		messages map[string][]discordgo.Message
+		want     map[string][]string
+		pruned   int
+	}{
+		{
+			name: "keeps the newest messages in their original order",
+			opts: BackupCreateOpts{BackupMessages: true, PerChannel: 2},
+			messages: map[string][]discordgo.Message{
+				"1": testMessages("1", "10", "12", "9", "11"),
+				"2": testMessages("2", "20"),
+			},
+			want:   map[string][]string{"1": {"12", "11"}, "2": {"20"}},
+			pruned: 2,
+		},
+		{
+			name: "compares snowflakes numerically",
+			opts: BackupCreateOpts{BackupMessages: true, PerChannel: 1},
+			messages: map[string][]discordgo.Message{
+				"1": testMessages("1", "100", "99"),
+			},
+			want:   map[string][]string{"1": {"100"}},
+			pruned: 1,
+		},
+		{
+			name: "drops channels that were not selected",
+			opts: BackupCreateOpts{BackupMessages: true, PerChannel: 2, Channels: []string{"1"}},
+			messages: map[string][]discordgo.Message{
+				"1": testMessages("1", "10"),
+				"2": testMessages("2", "20", "21"),
+			},
+			want:   map[string][]string{"1": {"10"}},
+			pruned: 2,
+		},
+		{
+			name: "rollover keeps messages within the rolled over allocation",
+			opts: BackupCreateOpts{BackupMessages: true, PerChannel: 2, Legacy: &LegacyBackupCreateOpts{RolloverLeftovers: true}},
+			messages: map[string][]discordgo.Message{
+				"1": testMessages("1", "10", "11", "12", "13"),
+				"2": testMessages("2", "20"),
+			},
+			want:   map[string][]string{"1": {"11", "12", "13"}, "2": {"20"}},
+			pruned: 1,
+		},
+		{
+			name: "messages disabled",
+			opts: BackupCreateOpts{PerChannel: 2},
+			messages: map[string][]discordgo.Message{
+				"1": testMessages("1", "10"),
+			},
+			want:   map[string][]string{},
+			pruned: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core := &CoreBackupData{
+				Channels: testChannels("1", "2"),
+				Messages: test.messages,
+				Options:  test.opts,
+			}
+
+			pruned := EnforceAllocations(core)
+			if pruned != test.pruned {
+				t.Errorf("expected %d pruned messages, got %d", test.pruned, pruned)
+			}
+
+			got := make(map[string][]string)
+			for id, messages := range core.Messages {
+				for _, msg := range messages {
+					got[id] = append(got[id], msg.ID)
+				}
+
+				if core.ChannelAllocation[id] != len(messages) {
+					t.Errorf("channel %s has allocation %d for %d messages", id, core.ChannelAllocation[id], len(messages))
+				}
+			}
+
+			if !maps.EqualFunc(got, test.want, slices.Equal) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+
+			if issues := CheckAllocations(core); len(issues) > 0 {
+				t.Fatalf("unexpected issues after enforcing: %v", issues)
+			}
+		})
+	}
+}
+
+func TestCheckAllocations(t *testing.T) {
+	core := &CoreBackupData{
+		Channels: testChannels("1", "2", "3"),
+		Messages: map[string][]discordgo.Message{
+			"1": testMessages("1", "10", "11", "12"),
+			"3": testMessages("3", "30"),
+		},
+		Options:           BackupCreateOpts{BackupMessages: true, PerChannel: 2, MaxMessages: 3, Channels: []string{"1", "2"}},
+		ChannelAllocation: map[string]int{"1": 3, "2": 1},
+	}
+
+	want := []AllocationIssue{
+		{Kind: AllocationIssueOverAllocation, ChannelID: "1", Expected: 2, Actual: 3},
+		{Kind: AllocationIssueMismatch, ChannelID: "3", Expected: 1, Actual: 0},
+		{Kind: AllocationIssueNotSelected, ChannelID: "3", Expected: 0, Actual: 1},
+		{Kind: AllocationIssueMismatch, ChannelID: "2", Expected: 0, Actual: 1},
+		{Kind: AllocationIssueOverMaxMessages, Expected: 3, Actual: 4},
+	}
+
+	got := CheckAllocations(core)
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
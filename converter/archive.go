@@ -0,0 +1,393 @@
+package converter
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The container format a converted backup is written in
+type OutputFormat string
+
+const (
+	// A tar file (the canonical ARB1 format)
+	OutputTar OutputFormat = "tar"
+
+	// A zip file with stored (uncompressed) entries, as entries are already compressed by their codec
+	OutputZip OutputFormat = "zip"
+
+	// A plain directory tree with one file per entry, mainly useful for debugging
+	OutputDir OutputFormat = "dir"
+)
+
+// All output formats
+var OutputFormats = []OutputFormat{OutputTar, OutputZip, OutputDir}
+
+// Parses an output format name, returning an error for unknown formats
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	for _, f := range OutputFormats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown output format: %s", name)
+}
+
+// Writes the entries of an ARB1 backup to an output target
+type ArchiveWriter interface {
+	// Writes a single entry. Entries are written in backup order, starting with the manifest
+	WriteEntry(name string, data []byte) error
+
+	// Finishes the output, no entries may be written afterwards
+	Close() error
+}
+
+type tarArchiveWriter struct {
+	w       *tar.Writer
+	modTime time.Time
+}
+
+// The latest ModTime a USTAR header can hold, in 11 octal digits of seconds since the epoch
+var maxUSTARTime = time.Unix(1<<33-1, 0)
+
+// Returns an ArchiveWriter writing a tar file to w, with modTime recorded for every entry
+//
+// USTAR headers cannot hold times before 1970 or after 2242, so such a modTime is recorded as
+// the epoch instead
+func NewTarArchiveWriter(w io.Writer, modTime time.Time) ArchiveWriter {
+	if modTime.Before(time.Unix(0, 0)) || modTime.After(maxUSTARTime) {
+		modTime = time.Time{}
+	}
+
+	return &tarArchiveWriter{
+		w:       tar.NewWriter(w),
+		modTime: modTime.UTC().Truncate(time.Second),
+	}
+}
+
+func (a *tarArchiveWriter) WriteEntry(name string, data []byte) error {
+	err := a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  a.modTime,
+		Format:   tar.FormatUSTAR,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = a.w.Write(data)
+	return err
+}
+
+func (a *tarArchiveWriter) Close() error {
+	return a.w.Close()
+}
+
+type zipArchiveWriter struct {
+	w       *zip.Writer
+	modTime time.Time
+}
+
+// Returns an ArchiveWriter writing a zip file to w, with modTime recorded for every entry
+func NewZipArchiveWriter(w io.Writer, modTime time.Time) ArchiveWriter {
+	return &zipArchiveWriter{
+		w:       zip.NewWriter(w),
+		modTime: modTime.UTC().Truncate(time.Second),
+	}
+}
+
+func (a *zipArchiveWriter) WriteEntry(name string, data []byte) error {
+	header := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Store,
+	}
+
+	if !a.modTime.IsZero() {
+		header.Modified = a.modTime
+	}
+
+	header.SetMode(0600)
+
+	w, err := a.w.CreateHeader(header)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.w.Close()
+}
+
+type dirArchiveWriter struct {
+	dir     string
+	modTime time.Time
+}
+
+// Returns an ArchiveWriter unpacking the backup into dir, one file per entry
+//
+// The directory is created if needed and must be empty so that no stale entries are mixed into the backup
+func NewDirArchiveWriter(dir string, modTime time.Time) (ArchiveWriter, error) {
+	err := os.MkdirAll(dir, 0755)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	existing, err := os.ReadDir(dir)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output directory: %w", err)
+	}
+
+	if len(existing) > 0 {
+		return nil, fmt.Errorf("output directory %s is not empty", dir)
+	}
+
+	return &dirArchiveWriter{
+		dir:     dir,
+		modTime: modTime.UTC().Truncate(time.Second),
+	}, nil
+}
+
+func (a *dirArchiveWriter) WriteEntry(name string, data []byte) error {
+	rel := filepath.FromSlash(name)
+
+	if !filepath.IsLocal(rel) {
+		return fmt.Errorf("entry name %s escapes the output directory", name)
+	}
+
+	path := filepath.Join(a.dir, rel)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path, data, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	if !a.modTime.IsZero() {
+		return os.Chtimes(path, a.modTime, a.modTime)
+	}
+
+	return nil
+}
+
+func (a *dirArchiveWriter) Close() error {
+	return nil
+}
+
+// Returns a writer for the given format writing into buf (tar and zip) or dir (dir)
+func newArchiveWriter(format OutputFormat, buf io.Writer, dir string, modTime time.Time) (ArchiveWriter, error) {
+	switch format {
+	case OutputTar, "":
+		return NewTarArchiveWriter(buf, modTime), nil
+	case OutputZip:
+		return NewZipArchiveWriter(buf, modTime), nil
+	case OutputDir:
+		if dir == "" {
+			return nil, fmt.Errorf("the dir output format requires an output directory")
+		}
+
+		return NewDirArchiveWriter(dir, modTime)
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// Returns the output format of tar or zip backup bytes
+func detectOutputFormat(data []byte) OutputFormat {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return OutputZip
+	}
+
+	return OutputTar
+}
+
+// Reads the entries of a tar or zip backup in order, along with the modification time of the manifest
+//
+// Archives holding an entry name more than once are rejected, as readers keying entries by name
+// would otherwise see only one of the copies
+func readArchive(data []byte, limit int64) ([]tarSection, time.Time, error) {
+	entries, modTime, err := readArchiveEntries(data, limit)
+
+	if err != nil {
+		return nil, modTime, err
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if seen[e.name] {
+			return nil, modTime, fmt.Errorf("entry %s appears more than once", e.name)
+		}
+		seen[e.name] = true
+	}
+
+	return entries, modTime, nil
+}
+
+func readArchiveEntries(data []byte, limit int64) ([]tarSection, time.Time, error) {
+	var entries []tarSection
+	var modTime time.Time
+	remaining := limit
+
+	if detectOutputFormat(data) == OutputZip {
+		zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+
+		if err != nil {
+			return nil, modTime, err
+		}
+
+		for _, file := range zipReader.File {
+			if file.FileInfo().IsDir() {
+				continue
+			}
+
+			// Deflated entries can expand far beyond the size of the archive, so refuse them before inflating
+			if file.UncompressedSize64 > uint64(remaining) {
+				return nil, modTime, fmt.Errorf("%w: entries add up to more than %d bytes", ErrLimitExceeded, limit)
+			}
+
+			r, err := file.Open()
+
+			if err != nil {
+				return nil, modTime, err
+			}
+
+			entry, err := readArchiveEntry(r, limit, &remaining)
+			r.Close()
+
+			if err != nil {
+				return nil, modTime, err
+			}
+
+			if file.Name == ManifestName {
+				modTime = file.Modified
+			}
+
+			entries = append(entries, tarSection{name: file.Name, data: entry})
+		}
+
+		return entries, modTime, nil
+	}
+
+	tarReader := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tarReader.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, modTime, err
+		}
+
+		// Sparse entries expand their holes when read, so their size says nothing about the size of the archive
+		if header.Typeflag == tar.TypeGNUSparse || hasSparseRecords(header) {
+			return nil, modTime, fmt.Errorf("entry %s is a sparse file", header.Name)
+		}
+
+		if header.Size > remaining {
+			return nil, modTime, fmt.Errorf("%w: entries add up to more than %d bytes", ErrLimitExceeded, limit)
+		}
+
+		entry, err := readArchiveEntry(tarReader, limit, &remaining)
+
+		if err != nil {
+			return nil, modTime, err
+		}
+
+		if header.Name == ManifestName {
+			modTime = header.ModTime
+		}
+
+		entries = append(entries, tarSection{name: header.Name, data: entry})
+	}
+
+	return entries, modTime, nil
+}
+
+// Reads an archive entry, counting it against the remaining bytes the entries of the archive
+// may add up to
+func readArchiveEntry(r io.Reader, limit int64, remaining *int64) ([]byte, error) {
+	entry, err := readLimited(r, *remaining)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(entry)) > *remaining {
+		return nil, fmt.Errorf("%w: entries add up to more than %d bytes", ErrLimitExceeded, limit)
+	}
+
+	*remaining -= int64(len(entry))
+	return entry, nil
+}
+
+// Reports whether a tar header describes a PAX (GNU format 0.x or 1.0) sparse file
+func hasSparseRecords(header *tar.Header) bool {
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reads the entries of a backup unpacked into a directory, keyed by slash separated name
+func readDir(dir string) (map[string]*bytes.Buffer, error) {
+	entries := make(map[string]*bytes.Buffer)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		entries[filepath.ToSlash(rel)] = bytes.NewBuffer(data)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
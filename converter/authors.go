@@ -0,0 +1,173 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// A reference to a user in CoreBackupData.Users, replacing a message's full author object
+//
+// References are marked explicitly with Ref, as an inline author may have nothing but an ID either
+type UserRef struct {
+	ID  string `json:"id"`
+	Ref bool   `json:"ref"`
+}
+
+// A message whose author is either a UserRef or an inline *discordgo.User
+type compactMessage struct {
+	discordgo.Message
+	Author any `json:"author"`
+}
+
+// The encoded form of CoreBackupData with deduplicated authors
+type compactCoreBackupData struct {
+	*CoreBackupData
+	Messages map[string][]compactMessage `json:"messages"`
+}
+
+// Only the author references of messages encoded with deduplicated authors, decoded separately
+// as discordgo.Message has no room for the Ref marker
+type authorRefs struct {
+	Author *UserRef `json:"author"`
+}
+
+type coreAuthorRefs struct {
+	Messages map[string][]authorRefs `json:"messages"`
+}
+
+// Hoists message authors into core.Users, returning the number of authors that are encoded as references
+//
+// The first copy of each user (in channel ID then message order) becomes the canonical one.
+// Only authors identical to the canonical copy are encoded as references, those differing
+// from it (e.g. after a username change) are kept inline, so deduplication is lossless.
+// The messages themselves keep their full authors
+func DedupAuthors(core *CoreBackupData) int {
+	if core.Users == nil {
+		core.Users = make(map[string]discordgo.User)
+	}
+
+	var replaced int
+	for _, channelID := range sortedKeys(core.Messages) {
+		for _, msg := range core.Messages[channelID] {
+			author := msg.Author
+
+			if author == nil || author.ID == "" {
+				continue
+			}
+
+			canonical, ok := core.Users[author.ID]
+
+			if !ok {
+				core.Users[author.ID] = *author
+				canonical = *author
+			}
+
+			if canonical == *author {
+				replaced++
+			}
+		}
+	}
+
+	return replaced
+}
+
+// Drops core.Users, undoing DedupAuthors
+//
+// Messages always hold their full authors in memory, OpenBackup resolves the references while reading
+func InflateAuthors(core *CoreBackupData) {
+	core.Users = nil
+}
+
+// Replaces the authors of messages marked as references in refs with the full users from core.Users
+func resolveAuthorRefs(core *CoreBackupData, channelID string, messages []discordgo.Message, refs []authorRefs) error {
+	if len(refs) != len(messages) {
+		return fmt.Errorf("channel %s has %d messages but %d authors", channelID, len(messages), len(refs))
+	}
+
+	for i, ref := range refs {
+		if ref.Author == nil || !ref.Author.Ref {
+			continue
+		}
+
+		user, ok := core.Users[ref.Author.ID]
+
+		if !ok {
+			return fmt.Errorf("message %s references unknown user %s", messages[i].ID, ref.Author.ID)
+		}
+
+		messages[i].Author = &user
+	}
+
+	return nil
+}
+
+// Decodes the author references of an encoded core.json and resolves them, see resolveAuthorRefs
+func resolveCoreAuthorRefs(core *CoreBackupData, data []byte) error {
+	var refs coreAuthorRefs
+
+	err := json.Unmarshal(data, &refs)
+
+	if err != nil {
+		return err
+	}
+
+	for _, channelID := range sortedKeys(core.Messages) {
+		err = resolveAuthorRefs(core, channelID, core.Messages[channelID], refs.Messages[channelID])
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the value to JSON encode for the core backup data
+//
+// With deduplicated authors, authors identical to their entry in core.Users are encoded as
+// {"id": ..., "ref": true} references instead of full user objects
+func coreEncoding(core *CoreBackupData) any {
+	if len(core.Users) == 0 {
+		return core
+	}
+
+	compact := &compactCoreBackupData{
+		CoreBackupData: core,
+		Messages:       make(map[string][]compactMessage, len(core.Messages)),
+	}
+
+	for channelID, messages := range core.Messages {
+		compact.Messages[channelID] = compactMessages(core.Users, messages)
+	}
+
+	return compact
+}
+
+// Returns the value to JSON encode for a list of messages of the core backup data, see coreEncoding
+func messagesEncoding(core *CoreBackupData, messages []discordgo.Message) any {
+	if len(core.Users) == 0 {
+		return messages
+	}
+
+	return compactMessages(core.Users, messages)
+}
+
+func compactMessages(users map[string]discordgo.User, messages []discordgo.Message) []compactMessage {
+	compact := make([]compactMessage, 0, len(messages))
+	for _, msg := range messages {
+		cm := compactMessage{Message: msg}
+
+		if msg.Author == nil {
+			cm.Author = nil
+		} else if user, ok := users[msg.Author.ID]; ok && user == *msg.Author {
+			cm.Author = UserRef{ID: msg.Author.ID, Ref: true}
+		} else {
+			cm.Author = msg.Author
+		}
+
+		compact = append(compact, cm)
+	}
+	return compact
+}
@@ -0,0 +1,154 @@
+package converter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/anti-raid/legacybackupconverter/internal/legacytest"
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestDedupAuthorsRoundTrip(t *testing.T) {
+	canonical := &discordgo.User{ID: "7", Username: "alice", Avatar: "abc"}
+
+	messages := []discordgo.Message{
+		{ID: "1", Author: canonical},
+		{ID: "2", Author: &discordgo.User{ID: "7", Username: "alice", Avatar: "abc"}},
+		{ID: "3", Author: &discordgo.User{ID: "7", Avatar: "def"}}, // No username, but not a reference
+		{ID: "4", Author: &discordgo.User{ID: "7"}},
+		{ID: "5"},
+		{ID: "6", Author: &discordgo.User{Username: "webhook"}},
+		{ID: "7", Author: &discordgo.User{ID: "8", Bot: true}},
+	}
+
+	encodings := []struct {
+		name   string
+		encode func(core *CoreBackupData) any
+		decode func(t *testing.T, core *CoreBackupData, data []byte) []discordgo.Message
+	}{
+		{
+			name:   "core",
+			encode: coreEncoding,
+			decode: func(t *testing.T, core *CoreBackupData, data []byte) []discordgo.Message {
+				var decoded CoreBackupData
+				err := json.Unmarshal(data, &decoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = resolveCoreAuthorRefs(&decoded, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return decoded.Messages["100"]
+			},
+		},
+		{
+			name: "split messages",
+			encode: func(core *CoreBackupData) any {
+				return messagesEncoding(core, core.Messages["100"])
+			},
+			decode: func(t *testing.T, core *CoreBackupData, data []byte) []discordgo.Message {
+				var decoded []discordgo.Message
+				var refs []authorRefs
+				err := json.Unmarshal(data, &decoded)
+				if err == nil {
+					err = json.Unmarshal(data, &refs)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = resolveAuthorRefs(core, "100", decoded, refs)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return decoded
+			},
+		},
+	}
+
+	for _, encoding := range encodings {
+		t.Run(encoding.name, func(t *testing.T) {
+			core := &CoreBackupData{Messages: map[string][]discordgo.Message{"100": messages}}
+
+			replaced := DedupAuthors(core)
+			if replaced != 3 {
+				t.Errorf("expected 3 authors replaced by references, got %d", replaced)
+			}
+
+			data, err := json.Marshal(encoding.encode(core))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := encoding.decode(t, core, data)
+			if len(got) != len(messages) {
+				t.Fatalf("expected %d messages, got %d", len(messages), len(got))
+			}
+
+			for i := range messages {
+				if !reflect.DeepEqual(got[i].Author, messages[i].Author) {
+					t.Errorf("message %s: expected author %+v, got %+v", messages[i].ID, messages[i].Author, got[i].Author)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveAuthorRefsRejectsUnknownUsers(t *testing.T) {
+	core := &CoreBackupData{Users: map[string]discordgo.User{"7": {ID: "7"}}}
+	messages := []discordgo.Message{{ID: "1"}}
+
+	err := resolveAuthorRefs(core, "100", messages, []authorRefs{{Author: &UserRef{ID: "8", Ref: true}}})
+	if err == nil {
+		t.Fatal("expected an error for a reference to an unknown user")
+	}
+}
+
+func TestDedupAuthorsSavesSpace(t *testing.T) {
+	legacy := legacytest.Backup(t, 500, "")
+
+	full, err := ConvertFileWithOptions(legacy, "", ConvertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dedup, err := ConvertFileWithOptions(legacy, "", ConvertOptions{DedupAuthors: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dedup.CoreSize >= full.CoreSize {
+		t.Fatalf("expected deduplicated authors to shrink core.json.gz, got %d bytes from %d", dedup.CoreSize, full.CoreSize)
+	}
+
+	t.Logf("core.json.gz: %d bytes, %d with deduplicated authors", full.CoreSize, dedup.CoreSize)
+}
+
+func BenchmarkDedupAuthors(b *testing.B) {
+	legacy := legacytest.Backup(b, 2000, "")
+
+	for _, dedup := range []bool{false, true} {
+		name := "inline"
+		if dedup {
+			name = "dedup"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			var size int
+			for b.Loop() {
+				res, err := ConvertFileWithOptions(legacy, "", ConvertOptions{DedupAuthors: dedup})
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = res.CoreSize
+			}
+
+			b.ReportMetric(float64(size), "core-bytes")
+		})
+	}
+}
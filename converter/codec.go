@@ -0,0 +1,178 @@
+package converter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The compression codec used for the JSON entries (core.json and per-channel messages) of a backup
+//
+// The codec determines the extension of these entries and is recorded in the manifest
+type Codec string
+
+const (
+	CodecGzip Codec = "gzip"
+	CodecZstd Codec = "zstd"
+	CodecNone Codec = "none"
+)
+
+// All codecs, in order of preference
+var Codecs = []Codec{CodecGzip, CodecZstd, CodecNone}
+
+// Parses a codec name, returning an error for unknown codecs
+func ParseCodec(name string) (Codec, error) {
+	for _, c := range Codecs {
+		if string(c) == name {
+			return c, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown codec: %s", name)
+}
+
+// Returns an error if level is not a compression level of the codec (see Encode)
+func (c Codec) ValidateLevel(level int) error {
+	var max int
+	switch c {
+	case CodecGzip:
+		max = gzip.BestCompression
+	case CodecZstd:
+		max = 22
+	case CodecNone:
+		if level != 0 {
+			return errors.New("the none codec takes no compression level")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown codec: %s", c)
+	}
+
+	if level < 0 || level > max {
+		return fmt.Errorf("invalid %s compression level %d (expected 1 to %d, or 0 for the default)", c, level, max)
+	}
+
+	return nil
+}
+
+// Returns the file extension of entries written with the codec
+func (c Codec) Extension() string {
+	switch c {
+	case CodecGzip:
+		return ".gz"
+	case CodecZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// Returns the name of a JSON entry (without extension) when written with the codec
+func (c Codec) EntryName(name string) string {
+	return name + c.Extension()
+}
+
+// Compresses data at the given level
+//
+// A level of 0 uses the codec's default level. Gzip levels range from 1 to 9,
+// zstd levels from 1 to 22 (mapped to the nearest zstd encoder level)
+func (c Codec) Encode(data []byte, level int) ([]byte, error) {
+	switch c {
+	case CodecGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		// The gzip header is left with no name and a zero ModTime so that the output is reproducible
+		buf := bytes.NewBuffer([]byte{})
+		gzWriter, err := gzip.NewWriterLevel(buf, level)
+		if err != nil {
+			return nil, err
+		}
+		_, err = gzWriter.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		err = gzWriter.Close()
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CodecZstd:
+		zstdLevel := zstd.SpeedDefault
+		if level != 0 {
+			zstdLevel = zstd.EncoderLevelFromZstd(level)
+		}
+
+		// A single goroutine keeps the output deterministic
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstdLevel), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer enc.Close()
+		return enc.EncodeAll(data, nil), nil
+	case CodecNone:
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unknown codec: %s", c)
+	}
+}
+
+// Decompresses data written with the codec, returning ErrLimitExceeded if it decompresses to more than limit bytes
+//
+// The limit guards against small entries expanding to huge ones (compression bombs)
+func (c Codec) Decode(data []byte, limit int64) ([]byte, error) {
+	var decoded []byte
+
+	switch c {
+	case CodecGzip:
+		gzReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gzReader.Close()
+		decoded, err = readLimited(gzReader, limit)
+		if err != nil {
+			return nil, err
+		}
+	case CodecZstd:
+		// The memory limit also caps the window size a frame may declare, with some room for small limits
+		dec, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(max(limit, 1<<20))))
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		decoded, err = readLimited(dec, limit)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, fmt.Errorf("%w: entry decompresses to more than %d bytes", ErrLimitExceeded, limit)
+		}
+		if err != nil {
+			return nil, err
+		}
+	case CodecNone:
+		decoded = data
+	default:
+		return nil, fmt.Errorf("unknown codec: %s", c)
+	}
+
+	if int64(len(decoded)) > limit {
+		return nil, fmt.Errorf("%w: entry decompresses to more than %d bytes", ErrLimitExceeded, limit)
+	}
+
+	return decoded, nil
+}
+
+// Reads r up to one byte past limit, enough for Decode to tell that the limit was exceeded
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit < math.MaxInt64 {
+		limit++
+	}
+
+	return io.ReadAll(io.LimitReader(r, limit))
+}
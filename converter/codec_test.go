@@ -0,0 +1,226 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/internal/legacytest"
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCodecDecodeLimit(t *testing.T) {
+	data := bytes.Repeat([]byte{0}, 64<<20)
+
+	for _, codec := range Codecs {
+		t.Run(string(codec), func(t *testing.T) {
+			encoded, err := codec.Encode(data, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := codec.Decode(encoded, math.MaxInt64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Fatal("decoded data differs")
+			}
+
+			_, err = codec.Decode(encoded, int64(len(data)))
+			if err != nil {
+				t.Fatalf("unexpected error at the exact size: %s", err)
+			}
+
+			_, err = codec.Decode(encoded, 1<<20)
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("expected %v, got %v", ErrLimitExceeded, err)
+			}
+		})
+	}
+}
+
+func TestOpenBackupDecodedSizeLimit(t *testing.T) {
+	res, err := ConvertFileWithOptions(legacytest.Backup(t, 50, ""), "", ConvertOptions{SplitMessages: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenBackup(res.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, err := b.Codec().Decode(b.Entries["core.json.gz"].Bytes(), math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		size int64
+		err  error
+	}{
+		{"default", 0, nil},
+		{"disabled", -1, nil},
+		{"too small for core.json", 16, ErrLimitExceeded},
+		{"room for core.json only", int64(len(core)), ErrLimitExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := OpenBackupWithOptions(res.Data, ReadOptions{MaxDecodedSize: test.size})
+			if test.err == nil && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// The JSON of a synthetic guild of 50 channels with 1000 messages each from 200 authors
+func benchmarkCore(b *testing.B) []byte {
+	b.Helper()
+
+	const channels, messagesPerChannel, authors = 50, 1000, 200
+
+	core := &CoreBackupData{
+		Guild:             discordgo.Guild{ID: "1000000000000000000", Name: "Synthetic Guild"},
+		Messages:          make(map[string][]discordgo.Message, channels),
+		ChannelAllocation: make(map[string]int, channels),
+	}
+
+	users := make([]*discordgo.User, authors)
+	for i := range users {
+		users[i] = &discordgo.User{
+			ID:         strconv.Itoa(2000000000000000000 + i),
+			Username:   "user" + strconv.Itoa(i),
+			GlobalName: "User " + strconv.Itoa(i),
+			Avatar:     fmt.Sprintf("%032x", i*7919),
+		}
+	}
+
+	id := 3000000000000000000
+	for c := range channels {
+		channelID := strconv.Itoa(1100000000000000000 + c)
+		core.Channels = append(core.Channels, discordgo.Channel{ID: channelID, Name: "channel-" + strconv.Itoa(c), GuildID: core.Guild.ID})
+
+		msgs := make([]discordgo.Message, 0, messagesPerChannel)
+		for m := range messagesPerChannel {
+			id += 1 + (m*31)%97
+			msgs = append(msgs, discordgo.Message{
+				ID:        strconv.Itoa(id),
+				ChannelID: channelID,
+				GuildID:   core.Guild.ID,
+				Content:   fmt.Sprintf("message %d in channel %d with some typical chat content, number %x", m, c, id),
+				Author:    users[(m*13+c)%authors],
+				Timestamp: time.Unix(1700000000+int64(m)*60, 0).UTC(),
+			})
+		}
+
+		core.Messages[channelID] = msgs
+		core.ChannelAllocation[channelID] = len(msgs)
+	}
+
+	data, err := json.Marshal(core)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return data
+}
+
+var benchmarkCodecs = []struct {
+	codec Codec
+	level int
+}{
+	{CodecNone, 0},
+	{CodecGzip, 1},
+	{CodecGzip, 0},
+	{CodecGzip, 9},
+	{CodecZstd, 1},
+	{CodecZstd, 0},
+	{CodecZstd, 19},
+}
+
+func BenchmarkCodecEncode(b *testing.B) {
+	data := benchmarkCore(b)
+
+	for _, c := range benchmarkCodecs {
+		b.Run(fmt.Sprintf("%s/level=%d", c.codec, c.level), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+
+			var encoded []byte
+			for b.Loop() {
+				var err error
+				encoded, err = c.codec.Encode(data, c.level)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(len(encoded)), "encoded-bytes")
+			b.ReportMetric(100*float64(len(encoded))/float64(len(data)), "%ratio")
+		})
+	}
+}
+
+func BenchmarkCodecDecode(b *testing.B) {
+	data := benchmarkCore(b)
+
+	for _, c := range benchmarkCodecs {
+		b.Run(fmt.Sprintf("%s/level=%d", c.codec, c.level), func(b *testing.B) {
+			encoded, err := c.codec.Encode(data, c.level)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(data)))
+
+			for b.Loop() {
+				_, err := c.codec.Decode(encoded, DefaultMaxDecodedSize)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCodecValidateLevel(t *testing.T) {
+	tests := []struct {
+		codec Codec
+		level int
+		valid bool
+	}{
+		{CodecGzip, 0, true},
+		{CodecGzip, 9, true},
+		{CodecGzip, 10, false},
+		{CodecGzip, -1, false},
+		{CodecZstd, 22, true},
+		{CodecZstd, 23, false},
+		{CodecNone, 0, true},
+		{CodecNone, 1, false},
+	}
+
+	for _, test := range tests {
+		err := test.codec.ValidateLevel(test.level)
+		if (err == nil) != test.valid {
+			t.Errorf("%s level %d: expected valid = %v, got %v", test.codec, test.level, test.valid, err)
+		}
+
+		if err == nil {
+			_, err = test.codec.Encode([]byte("{}"), test.level)
+			if err != nil {
+				t.Errorf("%s level %d: %s", test.codec, test.level, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Returns an error wrapping ErrCanceled and the cause of the cancellation if ctx is done
+func checkContext(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", ErrCanceled, context.Cause(ctx))
+	}
+
+	return nil
+}
+
+type backgroundKey struct{}
+
+// Returns a copy of ctx tracking the steps of conversions under it that keep running in the
+// background after cancellation, along with a function waiting for them to return
+//
+// Callers bounding how many conversions run at once should wait before starting another one
+func WithBackgroundWait(ctx context.Context) (context.Context, func()) {
+	wg := new(sync.WaitGroup)
+	return context.WithValue(ctx, backgroundKey{}, wg), wg.Wait
+}
+
+// Runs fn in a goroutine, returning early if ctx is done before fn returns
+//
+// This is used for long uninterruptible steps (key derivation, decryption, encoding). On
+// cancellation fn keeps running in the background and its result is discarded, so fn
+// must not touch state the caller uses afterwards. See WithBackgroundWait for waiting for fn
+func runContext[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	var zero T
+
+	err := checkContext(ctx)
+
+	if err != nil {
+		return zero, err
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+
+	wg, _ := ctx.Value(backgroundKey{}).(*sync.WaitGroup)
+	if wg != nil {
+		wg.Add(1)
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+
+		// Done before sending, so that the wait of WithBackgroundWait does not block once runContext returned fn's result
+		if wg != nil {
+			wg.Done()
+		}
+
+		done <- result{value, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return zero, checkContext(ctx)
+	case r := <-done:
+		return r.value, r.err
+	}
+}
+
+// An ArchiveWriter that stops writing entries once its context is done
+type contextArchiveWriter struct {
+	ctx context.Context
+	w   ArchiveWriter
+}
+
+func (c contextArchiveWriter) WriteEntry(name string, data []byte) error {
+	err := checkContext(c.ctx)
+
+	if err != nil {
+		return err
+	}
+
+	return c.w.WriteEntry(name, data)
+}
+
+func (c contextArchiveWriter) Close() error {
+	err := checkContext(c.ctx)
+
+	if err != nil {
+		return err
+	}
+
+	return c.w.Close()
+}
@@ -0,0 +1,50 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithBackgroundWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, wait := WithBackgroundWait(ctx)
+
+	var finished atomic.Bool
+	unblock := make(chan struct{})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := runContext(ctx, func() (struct{}, error) {
+		<-unblock
+		finished.Store(true)
+		return struct{}{}, nil
+	})
+	if !errors.Is(err, ErrCanceled) {
+		t.Fatalf("expected %v, got %v", ErrCanceled, err)
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("wait returned while the step was still running")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(unblock)
+	<-waited
+
+	if !finished.Load() {
+		t.Fatal("wait returned before the step finished")
+	}
+}
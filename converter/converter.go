@@ -2,91 +2,136 @@ package converter
 
 import (
 	"bytes"
-	"errors"
+	"context"
+	"crypto/ed25519"
 	"fmt"
+	"log/slog"
+	"slices"
+	"time"
 
-	"github.com/anti-raid/legacybackupconverter/iblfile"
 	"github.com/bwmarrin/discordgo"
 )
 
+// Converts a legacy backup to an ARB1 backup, recording the current time as the conversion time
+//
+// Use ConvertFileAt with a zero time for byte-for-byte reproducible output
 func ConvertFile(data []byte, password string) ([]byte, error) {
-	var aes256src = iblfile.AES256Source{}
-	var noencryptsrc = iblfile.NoEncryptionSource{}
+	return ConvertFileAt(data, password, time.Now())
+}
 
-	qblock, err := iblfile.QuickBlockParser(bytes.NewReader(data))
+// Converts a legacy backup to an ARB1 backup, recording convertedAt as the conversion time
+//
+// The output is a pure function of the inputs. With a zero convertedAt, no conversion time is
+// recorded so that converting the same legacy backup always produces byte-for-byte identical output
+func ConvertFileAt(data []byte, password string, convertedAt time.Time) ([]byte, error) {
+	res, err := ConvertFileWithOptions(data, password, ConvertOptions{ConvertedAt: convertedAt})
 	if err != nil {
 		return nil, err
 	}
 
-	var encryptor iblfile.AutoEncryptor
-	switch string(qblock.Encryptor) {
-	case noencryptsrc.ID():
-		encryptor = noencryptsrc
-	case aes256src.ID():
-		if password == "" {
-			return nil, errors.New("this backup is encrypted and hence requires a password to decrypt and convert")
-		}
-		aes256src.EncryptionKey = password
-		encryptor = &aes256src
-	default:
-		return nil, fmt.Errorf("unknown encryptor: %s", qblock.Encryptor)
-	}
+	return res.Data, nil
+}
 
-	f, err := iblfile.OpenAutoEncryptedFile_FullFile(bytes.NewReader(data), encryptor)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open autoencrypted file for conversion: %w", err)
+// Converts a legacy backup to an ARB1 backup
+//
+// The output is a pure function of the inputs and options
+func ConvertFileWithOptions(data []byte, password string, opts ConvertOptions) (*ConvertResult, error) {
+	return ConvertFileContext(context.Background(), data, password, opts)
+}
+
+// Like ConvertFileWithOptions, but stops with ErrCanceled as soon as ctx is done
+//
+// The context is checked between sections, channels and entries, and long uninterruptible
+// steps (key derivation, decryption, encoding, encryption) are abandoned on cancellation.
+// With the dir format, a canceled conversion may leave a partially written directory behind
+func ConvertFileContext(ctx context.Context, data []byte, password string, opts ConvertOptions) (*ConvertResult, error) {
+	if opts.MaxInputSize > 0 && int64(len(data)) > opts.MaxInputSize {
+		return nil, fmt.Errorf("%w: legacy backup is %d bytes, the limit is %d", ErrLimitExceeded, len(data), opts.MaxInputSize)
 	}
 
-	sections, err := f.Sections()
+	if opts.Format == OutputDir && opts.EncryptionPassword != "" {
+		return nil, fmt.Errorf("%w: encryption is not supported with the dir output format", ErrInvalidOptions)
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to read sections: %w", err)
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
 	}
 
-	meta, err := iblfile.ParseMetadata(sections)
+	opts.report(Progress{Stage: ProgressDecrypt, Total: 1, TotalBytes: int64(len(data))})
 
+	legacy, err := OpenLegacyBackupContext(ctx, data, password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+		return nil, err
 	}
 
-	if meta.Type != "backup.server" {
-		return nil, fmt.Errorf("internal error: invalid file type: %s, please contact support for more information", meta.Type)
-	}
+	opts.report(Progress{Stage: ProgressDecrypt, Done: 1, Total: 1, Bytes: int64(len(data)), TotalBytes: int64(len(data))})
 
-	if meta.FormatVersion != "a1" {
-		return nil, fmt.Errorf("internal error: invalid file format version: %s, please contact support for more information", meta.FormatVersion)
-	}
+	logger.Debug("opened legacy backup", "type", legacy.Meta.Type, "format_version", legacy.Meta.FormatVersion, "encrypted", legacy.Encrypted, "sections", len(legacy.Sections))
+
+	f := legacy.File
+	sections := legacy.Sections
 
 	// TODO: See https://github.com/ARChronoVault/jobserver/blob/master/jobs/backups/types.go for conversion steps
 
 	// 1. backup_opts
-	bo, err := readMsgpackSection[OldBackupCreateOpts](f, "backup_opts")
+	parseBytes := int64(sectionSize(sections, "backup_opts") + sectionSize(sections, "core/guild"))
+	opts.report(Progress{Stage: ProgressParse, Total: 2, TotalBytes: parseBytes})
+
+	bo, err := legacy.Options()
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get backup_opts: %w", err)
 	}
 
+	opts.report(Progress{Stage: ProgressParse, Done: 1, Total: 2, Bytes: int64(sectionSize(sections, "backup_opts")), TotalBytes: parseBytes})
+
 	// Convert to new spec
 	newBo := bo.ToNew()
 
+	// Options reflect what the converted backup holds, the original options remain in newBo.Legacy
+	if opts.SkipMessages {
+		logger.Debug("skipping all messages", "backup_messages", newBo.BackupMessages)
+		newBo.BackupMessages = false
+	}
+
+	if opts.SkipAssets {
+		logger.Debug("skipping guild assets", "backup_guild_assets", newBo.BackupGuildAssets)
+		newBo.BackupGuildAssets = []string{}
+	}
+
 	// 2. core/guild (guild and channels)
-	srcGuild, err := readMsgpackSection[discordgo.Guild](f, "core/guild")
+	srcGuild, err := runContext(ctx, legacy.Guild)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get core data: %w", err)
 	}
 
+	opts.report(Progress{Stage: ProgressParse, Done: 2, Total: 2, Bytes: parseBytes, TotalBytes: parseBytes})
+
 	if srcGuild.ID == "" {
 		return nil, fmt.Errorf("guild data is invalid [id is empty], likely an internal decoding error")
 	}
 
+	logger = logger.With("guild_id", srcGuild.ID)
+
 	channels := srcGuild.Channels
 
+	// Channels left out of the converted backup on purpose still exist as far as the options are concerned
+	var sourceChannels = make(map[string]bool, len(channels))
+
 	var channelsList []discordgo.Channel = make([]discordgo.Channel, 0, len(channels))
-	for _, channel := range channels {
+	for i, channel := range channels {
 		if channel == nil || channel.ID == "" {
+			logger.Debug("skipped nil or empty channel", "index", i)
 			continue // Skip nil or empty channels
 		}
+		sourceChannels[channel.ID] = true
+		if !isSnowflake(channel.ID) {
+			// The ID names the channel's legacy messages section and split messages entry
+			logger.Debug("skipped channel whose ID is not a snowflake", "index", i, "channel_id", channel.ID)
+			continue
+		}
 		channelsList = append(channelsList, *channel)
 	}
 
@@ -94,7 +139,14 @@ func ConvertFile(data []byte, password string) ([]byte, error) {
 		return nil, fmt.Errorf("sanity check failed during legacy backups migration: guild has no channels")
 	}
 
+	channelsList = filterChannels(channelsList, opts, logger)
+
+	if len(channelsList) == 0 {
+		return nil, fmt.Errorf("%w: the channel filters leave none of the backup's channels", ErrInvalidOptions)
+	}
+
 	// Trim out the big useless fields that do not even exist in the new spec
+	logger.Debug("dropped guild fields not in the new spec", "threads", len(srcGuild.Threads), "members", len(srcGuild.Members), "presences", len(srcGuild.Presences), "voice_states", len(srcGuild.VoiceStates))
 	srcGuild.Channels = nil
 	srcGuild.Threads = nil
 	srcGuild.Members = nil
@@ -104,25 +156,51 @@ func ConvertFile(data []byte, password string) ([]byte, error) {
 	// 3. messages
 	var messagesMap = make(map[string][]discordgo.Message)
 	var channelAllocations = make(map[string]int)
-	for _, channel := range channelsList {
+	var duplicateMessages int
+	var truncatedMessages int
+	var messageChannels = channelsList
+	if opts.SkipMessages {
+		messageChannels = nil
+	}
+
+	var messageBytes, messageTotalBytes int64
+	for _, channel := range messageChannels {
+		messageTotalBytes += int64(sectionSize(sections, "messages/"+channel.ID))
+	}
+
+	for i, channel := range messageChannels {
+		err = checkContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.report(Progress{Stage: ProgressMessages, Done: i, Total: len(messageChannels), Bytes: messageBytes, TotalBytes: messageTotalBytes, ChannelID: channel.ID})
+		messageBytes += int64(sectionSize(sections, "messages/"+channel.ID))
+
 		if _, ok := sections["messages/"+channel.ID]; !ok {
 			// No messages for this channel, skip it
+			logger.Debug("skipped channel without messages section", "channel_id", channel.ID)
 			continue
 		}
 
 		// Read messages for this channel
-		messages, err := readMsgpackSection[[]discordgo.Message](f, "messages/"+channel.ID)
+		messages, err := runContext(ctx, func() (*[]discordgo.Message, error) {
+			return readMsgpackSection[[]discordgo.Message](f, "messages/"+channel.ID)
+		})
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to get messages for channel %s: %w", channel.ID, err)
 		}
 
 		if messages == nil {
+			logger.Debug("skipped channel with nil messages", "channel_id", channel.ID)
 			continue // No messages for this channel
 		}
 
 		// Add the messages to the new spec
-		bmPtr, err := readMsgpackSection[[]*BackupMessage](f, "messages/"+channel.ID)
+		bmPtr, err := runContext(ctx, func() (*[]*BackupMessage, error) {
+			return readMsgpackSection[[]*BackupMessage](f, "messages/"+channel.ID)
+		})
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to get section: %w", err)
@@ -131,27 +209,59 @@ func ConvertFile(data []byte, password string) ([]byte, error) {
 		bm := *bmPtr
 
 		if len(bm) == 0 {
+			logger.Debug("skipped channel with empty message list", "channel_id", channel.ID)
 			continue // No messages for this channel
 		}
 
+		var nilMessages, droppedAttachments int
 		var messagesList []discordgo.Message = make([]discordgo.Message, 0, len(bm))
 		for _, msg := range bm {
-			if msg.Message == nil {
+			if msg == nil || msg.Message == nil {
+				nilMessages++
 				continue // Skip nil messages
 			}
 			msg := *msg.Message
-			msg.Attachments = nil // Remove attachments as they are not needed in the new spec
+			if !opts.KeepAttachments {
+				droppedAttachments += len(msg.Attachments)
+				msg.Attachments = nil // Remove attachments as they are not needed in the new spec
+			}
 			messagesList = append(messagesList, msg)
 		}
 
+		if nilMessages > 0 {
+			logger.Debug("skipped nil messages", "channel_id", channel.ID, "count", nilMessages)
+		}
+
+		if droppedAttachments > 0 {
+			logger.Debug("dropped attachment metadata", "channel_id", channel.ID, "count", droppedAttachments)
+		}
+
 		if len(messagesList) == 0 {
+			logger.Debug("skipped channel without valid messages", "channel_id", channel.ID)
 			continue // No valid messages for this channel
 		}
 
+		messagesList, removed := NormalizeMessages(messagesList)
+		duplicateMessages += removed
+
+		if removed > 0 {
+			logger.Debug("removed duplicate messages", "channel_id", channel.ID, "count", removed)
+		}
+
+		// Messages are sorted oldest first, so the newest ones are at the end
+		if opts.MaxMessagesPerChannel > 0 && len(messagesList) > opts.MaxMessagesPerChannel {
+			truncated := len(messagesList) - opts.MaxMessagesPerChannel
+			truncatedMessages += truncated
+			messagesList = messagesList[truncated:]
+
+			logger.Debug("truncated channel messages", "channel_id", channel.ID, "truncated", truncated, "kept", len(messagesList))
+		}
+
 		channelAllocations[channel.ID] = len(messagesList)
 		messagesMap[channel.ID] = messagesList
 	}
 
+	// Note that encoding/json writes map keys in sorted order, so Messages and ChannelAllocation encode deterministically
 	var coreBackupData = CoreBackupData{
 		Guild:             *srcGuild,
 		Channels:          channelsList,
@@ -160,6 +270,36 @@ func ConvertFile(data []byte, password string) ([]byte, error) {
 		ChannelAllocation: channelAllocations,
 	}
 
+	opts.report(Progress{Stage: ProgressMessages, Done: len(messageChannels), Total: len(messageChannels), Bytes: messageTotalBytes, TotalBytes: messageTotalBytes})
+
+	var res = &ConvertResult{
+		DuplicateMessages: duplicateMessages,
+		TruncatedMessages: truncatedMessages,
+	}
+
+	res.DanglingReferences = optionReferencesTo(&coreBackupData, sourceChannels, opts.PruneDanglingOptions)
+
+	for _, ref := range res.DanglingReferences {
+		if ref.Pruned {
+			logger.Debug("pruned dangling option reference", "option", ref.Option, "channel_id", ref.ChannelID)
+		}
+	}
+
+	res.AllocationIssues = CheckAllocations(&coreBackupData)
+
+	if opts.EnforceAllocations {
+		before := messageCounts(&coreBackupData)
+		res.PrunedMessages = EnforceAllocations(&coreBackupData)
+
+		for _, channelID := range sortedKeys(before) {
+			if kept := len(coreBackupData.Messages[channelID]); kept < before[channelID] {
+				logger.Debug("pruned messages exceeding allocation", "channel_id", channelID, "pruned", before[channelID]-kept, "kept", kept)
+			}
+		}
+	}
+
+	res.IntegrityIssues = CheckIntegrity(&coreBackupData)
+
 	var guildIcon bool
 	var guildBanner bool
 	var guildSplash bool
@@ -178,59 +318,275 @@ func ConvertFile(data []byte, password string) ([]byte, error) {
 	}
 
 	var tarfile = NewTarFile()
+	tarfile.ModTime = legacy.Meta.CreatedAt
+
+	// Legacy section names and entry names of the guild assets and attachment files to copy
+	var assetFiles [][2]string
+	if guildIcon {
+		assetFiles = append(assetFiles, [2]string{"assets/guildIcon", "assets/icon.jpg"})
+	}
+	if guildBanner {
+		assetFiles = append(assetFiles, [2]string{"assets/guildBanner", "assets/banner.jpg"})
+	}
+	if guildSplash {
+		assetFiles = append(assetFiles, [2]string{"assets/guildSplash", "assets/splash.jpg"})
+	}
+
+	var attachmentIDs []string
+	if opts.KeepAttachments {
+		attachmentIDs = attachmentFiles(&coreBackupData, sections, logger)
+	}
+
+	var assetBytes, assetTotalBytes int64
+	for _, asset := range assetFiles {
+		assetTotalBytes += int64(sectionSize(sections, asset[0]))
+	}
+	for _, id := range attachmentIDs {
+		assetTotalBytes += int64(sectionSize(sections, "attachments/"+id))
+	}
+
+	assetTotal := len(assetFiles) + len(attachmentIDs)
+	opts.report(Progress{Stage: ProgressAssets, Total: assetTotal, TotalBytes: assetTotalBytes})
 
 	// 4. guild icon, banner, splash
-	addAsset := func(oldAssetPath string, newAssetPath string) error {
-		bytes, err := f.Get(oldAssetPath)
+	for i, asset := range assetFiles {
+		bytes, err := f.Get(asset[0])
 
 		if err != nil {
-			return fmt.Errorf("failed to get guild %s: %w", oldAssetPath, err)
+			return nil, fmt.Errorf("failed to add guild %s: %w", asset[0], err)
 		}
 
 		if bytes == nil || bytes.Len() == 0 {
-			return fmt.Errorf("guild asset %s is empty, likely an internal error", oldAssetPath)
+			return nil, fmt.Errorf("guild asset %s is empty, likely an internal error", asset[0])
 		}
 
-		err = tarfile.WriteSection(bytes, newAssetPath)
+		err = tarfile.WriteSection(bytes, asset[1])
 
 		if err != nil {
-			return fmt.Errorf("failed to write guild %s: %w", oldAssetPath, err)
+			return nil, fmt.Errorf("failed to write guild %s: %w", asset[0], err)
 		}
 
-		return nil
+		logger.Debug("copied guild asset", "section", asset[0], "entry", asset[1], "bytes", bytes.Len())
+
+		assetBytes += int64(bytes.Len())
+		opts.report(Progress{Stage: ProgressAssets, Done: i + 1, Total: assetTotal, Bytes: assetBytes, TotalBytes: assetTotalBytes})
 	}
 
-	if guildIcon {
-		err = addAsset("assets/guildIcon", "assets/icon.jpg")
+	// 5. attachment files (only stored by some legacy backups)
+	for i, id := range attachmentIDs {
+		err = checkContext(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("failed to add guild icon: %w", err)
+			return nil, err
 		}
-	}
 
-	if guildBanner {
-		err = addAsset("assets/guildBanner", "assets/banner.jpg")
+		entryName := "attachments/" + id
+
+		err = tarfile.WriteSection(sections[entryName], entryName)
 		if err != nil {
-			return nil, fmt.Errorf("failed to add guild banner: %w", err)
+			return nil, fmt.Errorf("failed to write attachment %s: %w", id, err)
+		}
+
+		if coreBackupData.AttachmentFiles == nil {
+			coreBackupData.AttachmentFiles = make(map[string]string)
 		}
+
+		coreBackupData.AttachmentFiles[id] = entryName
+
+		assetBytes += int64(sections[entryName].Len())
+		opts.report(Progress{Stage: ProgressAssets, Done: len(assetFiles) + i + 1, Total: assetTotal, Bytes: assetBytes, TotalBytes: assetTotalBytes})
 	}
 
-	if guildSplash {
-		err = addAsset("assets/guildSplash", "assets/splash.jpg")
+	manifest := NewManifest(legacy, opts.ConvertedAt)
+
+	if opts.DedupAuthors {
+		res.DedupedAuthors = DedupAuthors(&coreBackupData)
+		logger.Debug("deduplicated message authors", "replaced", res.DedupedAuthors, "users", len(coreBackupData.Users))
+		manifest.Features = append(manifest.Features, FeatureDeduplicatedAuthors)
+	}
+
+	codec := opts.Codec
+	if codec == "" {
+		codec = CodecGzip
+	}
+
+	manifest.Codec = codec
+	if codec != CodecGzip {
+		manifest.Features = append(manifest.Features, FeatureEntryCodec)
+	}
+
+	// With split messages, core.json only holds an empty messages map and each channel gets its own entry
+	var coreData = coreBackupData
+	if opts.SplitMessages {
+		coreData.Messages = map[string][]discordgo.Message{}
+		manifest.Features = append(manifest.Features, FeatureSplitMessages)
+	}
+
+	encodeTotal := 1
+	if opts.SplitMessages {
+		encodeTotal += len(coreBackupData.Messages)
+	}
+
+	var encodeDone int
+	var encodeBytes int64
+	opts.report(Progress{Stage: ProgressEncode, Total: encodeTotal})
+
+	// Encoding large guilds takes a while, so it runs in the background to be abandoned on cancellation
+	writeJsonSection := func(v any, name string) error {
+		data, err := runContext(ctx, func() ([]byte, error) {
+			return encodeJsonEntry(v, codec, opts.CompressionLevel)
+		})
+
 		if err != nil {
-			return nil, fmt.Errorf("failed to add guild splash: %w", err)
+			return err
 		}
+
+		encodeDone++
+		encodeBytes += int64(len(data))
+		opts.report(Progress{Stage: ProgressEncode, Done: encodeDone, Total: encodeTotal, Bytes: encodeBytes})
+
+		return tarfile.WriteSection(bytes.NewBuffer(data), name)
 	}
 
 	// Write guild data
-	err = tarfile.WriteJsonGzSection(coreBackupData, "core.json.gz")
+	err = writeJsonSection(coreEncoding(&coreData), codec.EntryName("core.json"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to write core backup data: %w", err)
 	}
 
-	databytes, err := tarfile.Build()
+	res.CoreSize = tarfile.SectionSize(codec.EntryName("core.json"))
+
+	if opts.SplitMessages {
+		for _, channelID := range sortedKeys(coreBackupData.Messages) {
+			err = writeJsonSection(messagesEncoding(&coreBackupData, coreBackupData.Messages[channelID]), splitMessagesEntry(channelID, codec))
+			if err != nil {
+				return nil, fmt.Errorf("failed to write messages for channel %s: %w", channelID, err)
+			}
+		}
+	}
+
+	if opts.MaxOutputSize > 0 && int64(tarfile.Size()) > opts.MaxOutputSize {
+		return nil, fmt.Errorf("%w: backup entries add up to %d bytes, the limit is %d", ErrLimitExceeded, tarfile.Size(), opts.MaxOutputSize)
+	}
+
+	manifestBytes, err := tarfile.EncodeManifest(manifest)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build tar file: %w", err)
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	var trailing []tarSection
+	if opts.SigningKey != nil {
+		logger.Debug("signing manifest")
+		res.Signature = ed25519.Sign(opts.SigningKey, manifestBytes)
+		trailing = append(trailing, tarSection{name: SignatureName, data: res.Signature})
+	}
+
+	databytes := bytes.NewBuffer([]byte{})
+
+	w, err := newArchiveWriter(opts.Format, databytes, opts.OutputDir, tarfile.ModTime)
+	if err != nil {
+		return nil, err
 	}
 
-	return databytes.Bytes(), nil
+	progress := &progressArchiveWriter{
+		w:          contextArchiveWriter{ctx: ctx, w: w},
+		opts:       &opts,
+		total:      1 + len(tarfile.sections) + len(trailing),
+		totalBytes: int64(len(manifestBytes) + tarfile.Size()),
+	}
+	for _, t := range trailing {
+		progress.totalBytes += int64(len(t.data))
+	}
+
+	opts.report(Progress{Stage: ProgressWrite, Total: progress.total, TotalBytes: progress.totalBytes})
+
+	// The manifest is written as the first entry so readers can check the format version before anything else
+	err = tarfile.writeArchive(progress, manifestBytes, trailing...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write output: %w", err)
+	}
+
+	if opts.Format == OutputDir {
+		return res, nil
+	}
+
+	res.Data = databytes.Bytes()
+
+	if opts.EncryptionPassword != "" {
+		plain := res.Data
+		opts.report(Progress{Stage: ProgressEncrypt, Total: 1, TotalBytes: int64(len(plain))})
+
+		res.Data, err = runContext(ctx, func() ([]byte, error) {
+			return EncryptBackup(plain, opts.EncryptionPassword)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt backup: %w", err)
+		}
+
+		opts.report(Progress{Stage: ProgressEncrypt, Done: 1, Total: 1, Bytes: int64(len(plain)), TotalBytes: int64(len(plain))})
+	}
+
+	return res, nil
+}
+
+// Applies the IncludeChannels and ExcludeChannels options to a list of channels
+func filterChannels(channels []discordgo.Channel, opts ConvertOptions, logger *slog.Logger) []discordgo.Channel {
+	if len(opts.IncludeChannels) == 0 && len(opts.ExcludeChannels) == 0 {
+		return channels
+	}
+
+	var filtered = make([]discordgo.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if len(opts.IncludeChannels) > 0 && !slices.Contains(opts.IncludeChannels, channel.ID) {
+			logger.Debug("skipped channel not in include list", "channel_id", channel.ID)
+			continue
+		}
+
+		if slices.Contains(opts.ExcludeChannels, channel.ID) {
+			logger.Debug("skipped excluded channel", "channel_id", channel.ID)
+			continue
+		}
+
+		filtered = append(filtered, channel)
+	}
+
+	return filtered
+}
+
+// Returns the IDs of the attachments of the backup's messages whose files are stored in the
+// legacy backup, in channel and message order without duplicates. Only snowflake IDs are
+// returned, as the IDs become entry names
+func attachmentFiles(core *CoreBackupData, sections map[string]*bytes.Buffer, logger *slog.Logger) []string {
+	var ids []string
+	var seen = make(map[string]bool)
+	for _, channelID := range sortedKeys(core.Messages) {
+		for _, msg := range core.Messages[channelID] {
+			for _, attachment := range msg.Attachments {
+				if attachment == nil || attachment.ID == "" || seen[attachment.ID] {
+					continue // Already added, e.g. for a message that was forwarded
+				}
+
+				if !isSnowflake(attachment.ID) {
+					logger.Debug("attachment ID is not a snowflake, keeping metadata only", "channel_id", channelID, "message_id", msg.ID, "attachment_id", attachment.ID)
+					continue
+				}
+
+				if _, ok := sections["attachments/"+attachment.ID]; !ok {
+					logger.Debug("attachment file not in backup, keeping metadata only", "channel_id", channelID, "message_id", msg.ID, "attachment_id", attachment.ID)
+					continue // Only the metadata of this attachment was backed up
+				}
+
+				seen[attachment.ID] = true
+				ids = append(ids, attachment.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// Returns the size of a legacy backup section, or 0 if there is no such section
+func sectionSize(sections map[string]*bytes.Buffer, name string) int {
+	if section, ok := sections[name]; ok {
+		return section.Len()
+	}
+	return 0
 }
@@ -0,0 +1,298 @@
+package converter
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/iblfile"
+	"github.com/anti-raid/legacybackupconverter/internal/legacytest"
+	"github.com/bwmarrin/discordgo"
+)
+
+// The fixture of legacytest with the messages of channel 100 replaced and extra sections appended
+func legacyWithMessages(t *testing.T, extra []legacytest.Section, messages ...*discordgo.Message) []byte {
+	t.Helper()
+
+	var msgs []map[string]*discordgo.Message
+	for _, msg := range messages {
+		msgs = append(msgs, map[string]*discordgo.Message{"message": msg})
+	}
+
+	sections := legacytest.Sections(t, 3)
+	for i, section := range sections {
+		if section.Name == "messages/100" {
+			sections[i].Data = legacytest.Msgpack(t, msgs)
+		}
+	}
+
+	return legacytest.File(t, append(sections, extra...), "")
+}
+
+// Returns the names of the entries of a converted backup
+func entryNames(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	sections, _, err := readArchive(data, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, s := range sections {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+func TestConvertSkipsAttachmentFilesWithInvalidIDs(t *testing.T) {
+	legacy := legacyWithMessages(t,
+		[]legacytest.Section{
+			{Name: "attachments/../../../tmp/pwned", Data: []byte("EVIL")},
+			{Name: "attachments/a1", Data: []byte("LEGACY")},
+		},
+		&discordgo.Message{
+			ID:        "1000",
+			ChannelID: "100",
+			Attachments: []*discordgo.MessageAttachment{
+				{ID: "910000003", Filename: "ok.png"},
+				{ID: "../../../tmp/pwned", Filename: "evil.png"},
+				{ID: "a1", Filename: "legacy.png"},
+			},
+		},
+	)
+
+	res, err := ConvertFileWithOptions(legacy, "", ConvertOptions{KeepAttachments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := entryNames(t, res.Data)
+	for _, name := range names {
+		if strings.HasPrefix(name, "attachments/") && name != "attachments/910000003" {
+			t.Errorf("unexpected entry %s", name)
+		}
+	}
+	if !slices.Contains(names, "attachments/910000003") {
+		t.Errorf("expected attachments/910000003 in %v", names)
+	}
+
+	b, err := OpenBackup(res.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"910000003": "attachments/910000003"}
+	if !maps.Equal(b.Core.AttachmentFiles, want) {
+		t.Fatalf("expected attachment files %v, got %v", want, b.Core.AttachmentFiles)
+	}
+
+	// The metadata of skipped attachments is kept
+	if got := len(b.Core.Messages["100"][0].Attachments); got != 3 {
+		t.Fatalf("expected the metadata of 3 attachments, got %d", got)
+	}
+}
+
+func TestConvertAttachmentRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      ConvertOptions
+		metadata  bool
+		wantFiles map[string]string
+	}{
+		{"dropped by default", ConvertOptions{}, false, nil},
+		{"kept", ConvertOptions{KeepAttachments: true}, true, map[string]string{"910000003": "attachments/910000003"}},
+		{"kept with split messages", ConvertOptions{KeepAttachments: true, SplitMessages: true, DedupAuthors: true}, true, map[string]string{"910000003": "attachments/910000003"}},
+		{"dropped along with messages", ConvertOptions{KeepAttachments: true, SkipMessages: true}, false, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := ConvertFileWithOptions(legacytest.Backup(t, 5, ""), "", test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := OpenBackup(res.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !maps.Equal(b.Core.AttachmentFiles, test.wantFiles) {
+				t.Fatalf("expected attachment files %v, got %v", test.wantFiles, b.Core.AttachmentFiles)
+			}
+
+			for _, name := range entryNames(t, res.Data) {
+				if strings.HasPrefix(name, "attachments/") && test.wantFiles[strings.TrimPrefix(name, "attachments/")] != name {
+					t.Errorf("unexpected entry %s", name)
+				}
+			}
+
+			for id, name := range test.wantFiles {
+				if got := b.Entries[name].String(); got != "PNGDATA" {
+					t.Errorf("attachment %s holds %q", id, got)
+				}
+			}
+
+			var messages, withMetadata int
+			for _, msgs := range b.Core.Messages {
+				for _, msg := range msgs {
+					messages++
+					if len(msg.Attachments) > 0 && msg.Attachments[0].Filename == "f.png" && msg.Attachments[0].Size == 10 {
+						withMetadata++
+					}
+				}
+			}
+
+			if test.metadata && withMetadata != messages {
+				t.Errorf("expected attachment metadata on all %d messages, found it on %d", messages, withMetadata)
+			}
+			if !test.metadata && withMetadata != 0 {
+				t.Errorf("expected no attachment metadata, found it on %d messages", withMetadata)
+			}
+		})
+	}
+}
+
+func TestConvertSkipsChannelsWithInvalidIDs(t *testing.T) {
+	sections := legacytest.Sections(t, 3)
+	for i, section := range sections {
+		if section.Name == "core/guild" {
+			sections[i].Data = legacytest.Msgpack(t, discordgo.Guild{
+				ID: "1",
+				Channels: []*discordgo.Channel{
+					{ID: "100", Name: "general"},
+					{ID: "../../../tmp/pwned", Name: "evil"},
+				},
+			})
+		}
+	}
+	sections = append(sections, legacytest.Section{
+		Name: "messages/../../../tmp/pwned",
+		Data: legacytest.Msgpack(t, []map[string]*discordgo.Message{{"message": {ID: "1", ChannelID: "../../../tmp/pwned"}}}),
+	})
+
+	res, err := ConvertFileWithOptions(legacytest.File(t, sections, ""), "", ConvertOptions{SplitMessages: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{ManifestName, "assets/icon.jpg", "core.json.gz", "messages/100.json.gz"}
+	if names := entryNames(t, res.Data); !slices.Equal(names, want) {
+		t.Fatalf("expected entries %v, got %v", want, names)
+	}
+
+	b, err := OpenBackup(res.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.Core.Channels) != 1 || b.Core.Channels[0].ID != "100" {
+		t.Fatalf("expected only channel 100, got %v", b.Core.Channels)
+	}
+}
+
+func TestConvertSkipAssetsKeepsAnEmptyAssetList(t *testing.T) {
+	res, err := ConvertFileWithOptions(legacytest.Backup(t, 3, ""), "", ConvertOptions{SkipAssets: true, Codec: CodecNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenBackup(res.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(b.Entries["core.json"].String(), `"backupGuildAssets":[]`) {
+		t.Fatal("expected backupGuildAssets to be encoded as an empty list")
+	}
+
+	err = b.ValidateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slices.Contains(entryNames(t, res.Data), "assets/icon.jpg") {
+		t.Fatal("expected no guild assets")
+	}
+}
+
+func TestConvertClampsModTimesOutsideTheTarRange(t *testing.T) {
+	for _, createdAt := range []time.Time{
+		time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2300, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		t.Run(createdAt.Format("2006"), func(t *testing.T) {
+			meta, err := json.Marshal(iblfile.Meta{
+				CreatedAt:     createdAt,
+				Protocol:      iblfile.Protocol,
+				FormatVersion: "a1",
+				Type:          "backup.server",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sections := legacytest.Sections(t, 3)
+			sections[0] = legacytest.Section{Name: "meta", Data: meta}
+
+			for _, format := range []OutputFormat{OutputTar, OutputZip} {
+				res, err := ConvertFileWithOptions(legacytest.File(t, sections, ""), "", ConvertOptions{Format: format})
+				if err != nil {
+					t.Fatalf("%s: %s", format, err)
+				}
+
+				b, err := OpenBackup(res.Data)
+				if err != nil {
+					t.Fatalf("%s: %s", format, err)
+				}
+
+				if !b.Manifest.Source.CreatedAt.Equal(createdAt) {
+					t.Fatalf("%s: expected created_at %s in the manifest, got %s", format, createdAt, b.Manifest.Source.CreatedAt)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertChannelFiltersKeepOptionReferences(t *testing.T) {
+	legacy := legacytest.Backup(t, 3, "")
+
+	// Channel 999 and special allocation 555 reference channels that are not in the backup at all
+	want := []DanglingReference{
+		{Option: "channels", ChannelID: "999", Pruned: true},
+		{Option: "specialAllocations", ChannelID: "555", Pruned: true},
+	}
+
+	res, err := ConvertFileWithOptions(legacy, "", ConvertOptions{ExcludeChannels: []string{"100", "101"}, PruneDanglingOptions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(res.DanglingReferences, want) {
+		t.Fatalf("expected dangling references %v, got %v", want, res.DanglingReferences)
+	}
+
+	b, err := OpenBackup(res.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(b.Core.Options.Channels, []string{"100"}) {
+		t.Fatalf("expected the excluded channel to remain in options.channels, got %v", b.Core.Options.Channels)
+	}
+
+	if _, ok := b.Core.Options.SpecialAllocations["101"]; !ok {
+		t.Fatalf("expected the excluded channel to remain in options.specialAllocations, got %v", b.Core.Options.SpecialAllocations)
+	}
+
+	_, err = ConvertFileWithOptions(legacy, "", ConvertOptions{IncludeChannels: []string{"404"}})
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected %v for filters leaving no channels, got %v", ErrInvalidOptions, err)
+	}
+}
@@ -0,0 +1,336 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Legacy asset section names and the ARB1 entries they are converted to
+var convertedAssetNames = map[string]string{
+	"assets/guildIcon":   "assets/icon.jpg",
+	"assets/guildBanner": "assets/banner.jpg",
+	"assets/guildSplash": "assets/splash.jpg",
+}
+
+// Guild fields that are intentionally dropped during conversion and hence not compared
+var droppedGuildFields = []string{"channels", "threads", "members", "presences", "voice_states"}
+
+// The result of comparing a legacy backup with its converted ARB1 output
+type DiffReport struct {
+	Channels    []ChannelDiff `json:"channels"`
+	GuildFields []FieldDiff   `json:"guild_fields"`
+	Roles       []RoleDiff    `json:"roles"`
+	Assets      []AssetDiff   `json:"assets"`
+}
+
+// Message counts and mismatched message IDs for a single channel
+type ChannelDiff struct {
+	ChannelID        string   `json:"channel_id"`
+	LegacyCount      int      `json:"legacy_count"`      // Unique message IDs
+	LegacyDuplicates int      `json:"legacy_duplicates"` // Duplicate copies in the legacy backup, removed by conversion
+	ConvertedCount   int      `json:"converted_count"`
+	OnlyInLegacy     []string `json:"only_in_legacy"`
+	OnlyInConverted  []string `json:"only_in_converted"`
+}
+
+// Whether both sides hold the same set of messages for the channel
+func (c ChannelDiff) Equal() bool {
+	return c.LegacyCount == c.ConvertedCount && len(c.OnlyInLegacy) == 0 && len(c.OnlyInConverted) == 0
+}
+
+// A single top-level JSON field that differs between the legacy and converted object
+//
+// A nil side means the field is not present on that side
+type FieldDiff struct {
+	Field     string          `json:"field"`
+	Legacy    json.RawMessage `json:"legacy"`
+	Converted json.RawMessage `json:"converted"`
+}
+
+// A role that is missing on one side or whose fields differ
+type RoleDiff struct {
+	RoleID          string      `json:"role_id"`
+	OnlyInLegacy    bool        `json:"only_in_legacy,omitempty"`
+	OnlyInConverted bool        `json:"only_in_converted,omitempty"`
+	Fields          []FieldDiff `json:"fields,omitempty"`
+}
+
+// Byte level comparison of a guild asset
+//
+// LegacyName/ConvertedName is empty if the asset is not present on that side
+type AssetDiff struct {
+	LegacyName    string `json:"legacy_name"`
+	ConvertedName string `json:"converted_name"`
+	LegacySize    int    `json:"legacy_size"`
+	ConvertedSize int    `json:"converted_size"`
+	Equal         bool   `json:"equal"`
+}
+
+// Returns true if the diff found no differences at all
+func (r *DiffReport) Equal() bool {
+	for _, c := range r.Channels {
+		if !c.Equal() {
+			return false
+		}
+	}
+
+	for _, a := range r.Assets {
+		if !a.Equal {
+			return false
+		}
+	}
+
+	return len(r.GuildFields) == 0 && len(r.Roles) == 0
+}
+
+// Compares a legacy backup with a converted ARB1 backup
+func Diff(legacy *LegacyBackup, converted *Backup) (*DiffReport, error) {
+	report := &DiffReport{
+		Channels:    []ChannelDiff{},
+		GuildFields: []FieldDiff{},
+		Roles:       []RoleDiff{},
+		Assets:      []AssetDiff{},
+	}
+
+	// 1. messages
+	var channelIDs []string
+	channelIDs = append(channelIDs, legacy.MessageChannels()...)
+	for id := range converted.Core.Messages {
+		channelIDs = append(channelIDs, id)
+	}
+	slices.Sort(channelIDs)
+	channelIDs = slices.Compact(channelIDs)
+
+	for _, channelID := range channelIDs {
+		bm, err := legacy.Messages(channelID)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to get legacy messages for channel %s: %w", channelID, err)
+		}
+
+		var legacyIDs []string
+		var legacyDuplicates int
+		var seenIDs = make(map[string]bool, len(bm))
+		for _, msg := range bm {
+			if msg == nil || msg.Message == nil {
+				continue
+			}
+
+			if seenIDs[msg.Message.ID] {
+				legacyDuplicates++
+				continue
+			}
+
+			seenIDs[msg.Message.ID] = true
+			legacyIDs = append(legacyIDs, msg.Message.ID)
+		}
+
+		var convertedIDs []string
+		for _, msg := range converted.Core.Messages[channelID] {
+			convertedIDs = append(convertedIDs, msg.ID)
+		}
+
+		report.Channels = append(report.Channels, ChannelDiff{
+			ChannelID:        channelID,
+			LegacyCount:      len(legacyIDs),
+			LegacyDuplicates: legacyDuplicates,
+			ConvertedCount:   len(convertedIDs),
+			OnlyInLegacy:     array(subtract(legacyIDs, convertedIDs)),
+			OnlyInConverted:  array(subtract(convertedIDs, legacyIDs)),
+		})
+	}
+
+	// 2. guild and roles
+	srcGuild, err := legacy.Guild()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get legacy guild: %w", err)
+	}
+
+	guildFields, err := diffFields(srcGuild, &converted.Core.Guild, append(droppedGuildFields, "roles")...)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare guild: %w", err)
+	}
+
+	report.GuildFields = array(guildFields)
+
+	roleDiffs, err := diffRoles(srcGuild.Roles, converted.Core.Guild.Roles)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare roles: %w", err)
+	}
+
+	report.Roles = array(roleDiffs)
+
+	// 3. assets
+	var seen = make(map[string]bool)
+	for name, legacyBuf := range legacy.Sections {
+		if !strings.HasPrefix(name, "assets/") {
+			continue
+		}
+
+		convertedName := convertedAssetNames[name]
+		asset := AssetDiff{
+			LegacyName: name,
+			LegacySize: legacyBuf.Len(),
+		}
+
+		if convertedBuf, ok := converted.Entries[convertedName]; ok {
+			seen[convertedName] = true
+			asset.ConvertedName = convertedName
+			asset.ConvertedSize = convertedBuf.Len()
+			asset.Equal = bytes.Equal(legacyBuf.Bytes(), convertedBuf.Bytes())
+		}
+
+		report.Assets = append(report.Assets, asset)
+	}
+
+	for name, convertedBuf := range converted.Entries {
+		if !strings.HasPrefix(name, "assets/") || seen[name] {
+			continue
+		}
+
+		report.Assets = append(report.Assets, AssetDiff{
+			ConvertedName: name,
+			ConvertedSize: convertedBuf.Len(),
+		})
+	}
+
+	slices.SortFunc(report.Assets, func(a, b AssetDiff) int {
+		return strings.Compare(a.LegacyName+a.ConvertedName, b.LegacyName+b.ConvertedName)
+	})
+
+	return report, nil
+}
+
+func diffRoles(legacyRoles, convertedRoles []*discordgo.Role) ([]RoleDiff, error) {
+	var legacyByID = make(map[string]*discordgo.Role)
+	for _, role := range legacyRoles {
+		if role != nil {
+			legacyByID[role.ID] = role
+		}
+	}
+
+	var convertedByID = make(map[string]*discordgo.Role)
+	for _, role := range convertedRoles {
+		if role != nil {
+			convertedByID[role.ID] = role
+		}
+	}
+
+	var diffs []RoleDiff
+	for id, legacyRole := range legacyByID {
+		convertedRole, ok := convertedByID[id]
+
+		if !ok {
+			diffs = append(diffs, RoleDiff{RoleID: id, OnlyInLegacy: true})
+			continue
+		}
+
+		fields, err := diffFields(legacyRole, convertedRole)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) > 0 {
+			diffs = append(diffs, RoleDiff{RoleID: id, Fields: fields})
+		}
+	}
+
+	for id := range convertedByID {
+		if _, ok := legacyByID[id]; !ok {
+			diffs = append(diffs, RoleDiff{RoleID: id, OnlyInConverted: true})
+		}
+	}
+
+	slices.SortFunc(diffs, func(a, b RoleDiff) int {
+		return strings.Compare(a.RoleID, b.RoleID)
+	})
+
+	return diffs, nil
+}
+
+// Compares the top-level JSON fields of two values, ignoring the fields in skip
+func diffFields(legacy, converted any, skip ...string) ([]FieldDiff, error) {
+	legacyFields, err := jsonFields(legacy)
+
+	if err != nil {
+		return nil, err
+	}
+
+	convertedFields, err := jsonFields(converted)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range legacyFields {
+		names = append(names, name)
+	}
+	for name := range convertedFields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	var diffs []FieldDiff
+	for _, name := range names {
+		if slices.Contains(skip, name) {
+			continue
+		}
+
+		if !bytes.Equal(legacyFields[name], convertedFields[name]) {
+			diffs = append(diffs, FieldDiff{
+				Field:     name,
+				Legacy:    legacyFields[name],
+				Converted: convertedFields[name],
+			})
+		}
+	}
+
+	return diffs, nil
+}
+
+func jsonFields(v any) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+
+	err = json.Unmarshal(data, &fields)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// Returns the elements of a that are not in b
+func subtract(a, b []string) []string {
+	var inB = make(map[string]bool, len(b))
+	for _, v := range b {
+		inB[v] = true
+	}
+
+	var outp []string
+	for _, v := range a {
+		if !inB[v] {
+			outp = append(outp, v)
+		}
+	}
+
+	slices.Sort(outp)
+	return outp
+}
@@ -0,0 +1,31 @@
+package converter
+
+import (
+	"github.com/anti-raid/legacybackupconverter/iblfile"
+)
+
+// Encrypts an ARB1 backup with a password, giving an .arb1e backup
+//
+// The key is derived with Argon2id from the password and a random salt, so unlike the
+// ARB1 backup itself the encrypted output differs on every call
+func EncryptBackup(data []byte, password string) ([]byte, error) {
+	src := iblfile.AES256Source{EncryptionKey: password}
+	return src.Encrypt(data)
+}
+
+// Decrypts an .arb1e backup back into the ARB1 backup
+func DecryptBackup(data []byte, password string) ([]byte, error) {
+	if password == "" {
+		return nil, ErrPasswordRequired
+	}
+
+	src := iblfile.AES256Source{EncryptionKey: password}
+
+	decrypted, err := src.Decrypt(data)
+
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+
+	return decrypted, nil
+}
@@ -0,0 +1,38 @@
+package converter
+
+import "errors"
+
+// Error classes returned (wrapped) by the converter and reader, check them with errors.Is
+var (
+	// The legacy backup is encrypted but no password was given
+	ErrPasswordRequired = errors.New("this backup is encrypted and hence requires a password to decrypt and convert")
+
+	// The legacy backup could not be decrypted with the given password
+	ErrWrongPassword = errors.New("failed to decrypt backup, the password is likely wrong")
+
+	// The input is not a (legacy or ARB1) backup, or is corrupt
+	ErrInvalidBackup = errors.New("invalid backup")
+
+	// The input is a backup, but of a type, format version, feature or codec this converter does not support
+	ErrUnsupportedBackup = errors.New("unsupported backup")
+
+	// The backup does not match the checksums in its manifest
+	ErrIntegrity = errors.New("backup failed integrity check")
+
+	// The manifest signature does not match the given public key
+	ErrInvalidSignature = errors.New("invalid backup signature")
+
+	// A signature was required, but the backup carries none and no detached one was given
+	ErrUnsigned = errors.New("backup is not signed")
+
+	// The ConvertOptions contradict each other or leave nothing of the backup to convert
+	ErrInvalidOptions = errors.New("invalid conversion options")
+
+	// The input or output exceeds a limit set in ConvertOptions, or the entries of a backup
+	// decompress to more than allowed by ReadOptions
+	ErrLimitExceeded = errors.New("conversion limit exceeded")
+
+	// The context passed to the conversion was canceled or timed out. The error also wraps
+	// the context's error, so errors.Is(err, context.DeadlineExceeded) identifies timeouts
+	ErrCanceled = errors.New("conversion canceled")
+)
@@ -0,0 +1,114 @@
+package converter
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/internal/legacytest"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata")
+
+func TestConvertGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		opts   ConvertOptions
+	}{
+		{"default.arb1", ConvertOptions{}},
+		{"features.zip", ConvertOptions{KeepAttachments: true, DedupAuthors: true, SplitMessages: true, Codec: CodecZstd, Format: OutputZip}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			legacy := legacytest.Backup(t, 5, "")
+
+			res, err := ConvertFileWithOptions(legacy, "", test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join("testdata", test.golden)
+			if *update {
+				err = os.WriteFile(path, res.Data, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			golden, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(res.Data, golden) {
+				t.Fatalf("output differs from %s, rerun with -update if the change is intended", path)
+			}
+
+			// Converting again must not depend on anything but the input
+			again, err := ConvertFileWithOptions(legacy, "", test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(again.Data, res.Data) {
+				t.Fatal("converting the same backup twice gave different output")
+			}
+		})
+	}
+}
+
+func TestConvertFileAtIsReproducible(t *testing.T) {
+	data, err := ConvertFileAt(legacytest.Backup(t, 5, ""), "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden, err := os.ReadFile(filepath.Join("testdata", "default.arb1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, golden) {
+		t.Fatal("ConvertFileAt output differs from testdata/default.arb1")
+	}
+}
+
+func TestConvertFileAtRecordsConversionTime(t *testing.T) {
+	convertedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	data, err := ConvertFileAt(legacytest.Backup(t, 5, ""), "", convertedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenBackup(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Manifest.ConvertedAt == nil || !b.Manifest.ConvertedAt.Equal(convertedAt) {
+		t.Fatalf("expected converted_at %s, got %v", convertedAt, b.Manifest.ConvertedAt)
+	}
+}
+
+func TestConvertFileRecordsConversionTime(t *testing.T) {
+	before := time.Now().Truncate(time.Second)
+
+	data, err := ConvertFile(legacytest.Backup(t, 5, ""), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenBackup(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Manifest.ConvertedAt == nil || b.Manifest.ConvertedAt.Before(before) {
+		t.Fatalf("expected converted_at to be the current time, got %v", b.Manifest.ConvertedAt)
+	}
+}
@@ -0,0 +1,126 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// A class of broken reference inside the core backup data
+type IntegrityIssueKind string
+
+const (
+	// A channel's parent_id does not point to a category channel in the backup
+	IntegrityIssueChannelParent IntegrityIssueKind = "channel_parent"
+	// A permission overwrite targets a role that does not exist in the guild
+	IntegrityIssueOverwriteRole IntegrityIssueKind = "overwrite_role"
+	// A permission overwrite targets a member that does not exist in the guild
+	IntegrityIssueOverwriteMember IntegrityIssueKind = "overwrite_member"
+	// Messages are stored for a channel that does not exist in the backup
+	IntegrityIssueMessageChannelUnknown IntegrityIssueKind = "message_channel_unknown"
+	// A message's channel_id does not match the channel it is stored under
+	IntegrityIssueMessageChannelMismatch IntegrityIssueKind = "message_channel_mismatch"
+	// A message references (e.g. replies to) a message that is not in the backup
+	IntegrityIssueMessageReference IntegrityIssueKind = "message_reference"
+)
+
+// A single broken reference from one object (ID) to another (Target)
+type IntegrityIssue struct {
+	Kind   IntegrityIssueKind `json:"kind"`
+	ID     string             `json:"id"`
+	Target string             `json:"target"`
+}
+
+func (i IntegrityIssue) String() string {
+	return fmt.Sprintf("%s: %s -> %s", i.Kind, i.ID, i.Target)
+}
+
+// Checks references between channels, roles and messages in the core backup data
+//
+// Member overwrites are only checked if the guild has members, as members are not
+// carried over by the converter. Issues are returned in a stable order
+func CheckIntegrity(core *CoreBackupData) []IntegrityIssue {
+	var issues []IntegrityIssue
+
+	channels := make(map[string]*discordgo.Channel, len(core.Channels))
+	for i := range core.Channels {
+		channels[core.Channels[i].ID] = &core.Channels[i]
+	}
+
+	roles := make(map[string]bool, len(core.Guild.Roles))
+	for _, role := range core.Guild.Roles {
+		if role != nil {
+			roles[role.ID] = true
+		}
+	}
+
+	members := make(map[string]bool, len(core.Guild.Members))
+	for _, member := range core.Guild.Members {
+		if member != nil && member.User != nil {
+			members[member.User.ID] = true
+		}
+	}
+
+	// 1. channels
+	for _, channel := range core.Channels {
+		if channel.ParentID != "" {
+			parent, ok := channels[channel.ParentID]
+
+			if !ok || parent.Type != discordgo.ChannelTypeGuildCategory {
+				issues = append(issues, IntegrityIssue{Kind: IntegrityIssueChannelParent, ID: channel.ID, Target: channel.ParentID})
+			}
+		}
+
+		for _, overwrite := range channel.PermissionOverwrites {
+			if overwrite == nil {
+				continue
+			}
+
+			switch overwrite.Type {
+			case discordgo.PermissionOverwriteTypeRole:
+				if !roles[overwrite.ID] {
+					issues = append(issues, IntegrityIssue{Kind: IntegrityIssueOverwriteRole, ID: channel.ID, Target: overwrite.ID})
+				}
+			case discordgo.PermissionOverwriteTypeMember:
+				if len(members) > 0 && !members[overwrite.ID] {
+					issues = append(issues, IntegrityIssue{Kind: IntegrityIssueOverwriteMember, ID: channel.ID, Target: overwrite.ID})
+				}
+			}
+		}
+	}
+
+	// 2. messages
+	messageIDs := make(map[string]bool)
+	for _, messages := range core.Messages {
+		for _, msg := range messages {
+			messageIDs[msg.ID] = true
+		}
+	}
+
+	for _, channelID := range sortedKeys(core.Messages) {
+		if _, ok := channels[channelID]; !ok {
+			issues = append(issues, IntegrityIssue{Kind: IntegrityIssueMessageChannelUnknown, ID: channelID, Target: channelID})
+		}
+
+		for _, msg := range core.Messages[channelID] {
+			if msg.ChannelID != "" && msg.ChannelID != channelID {
+				issues = append(issues, IntegrityIssue{Kind: IntegrityIssueMessageChannelMismatch, ID: msg.ID, Target: msg.ChannelID})
+			}
+
+			if ref := msg.MessageReference; ref != nil && ref.MessageID != "" && !messageIDs[ref.MessageID] {
+				issues = append(issues, IntegrityIssue{Kind: IntegrityIssueMessageReference, ID: msg.ID, Target: ref.MessageID})
+			}
+		}
+	}
+
+	return issues
+}
+
+// Counts integrity issues per kind
+func SummarizeIntegrity(issues []IntegrityIssue) map[IntegrityIssueKind]int {
+	summary := make(map[IntegrityIssueKind]int)
+	for _, issue := range issues {
+		summary[issue.Kind]++
+	}
+	return summary
+}
@@ -0,0 +1,104 @@
+package converter
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	category := discordgo.Channel{ID: "1", Type: discordgo.ChannelTypeGuildCategory}
+	guild := discordgo.Guild{Roles: []*discordgo.Role{{ID: "50"}, nil}}
+
+	tests := []struct {
+		name     string
+		guild    discordgo.Guild
+		channels []discordgo.Channel
+		messages map[string][]discordgo.Message
+		want     []IntegrityIssue
+	}{
+		{
+			name:     "consistent",
+			guild:    guild,
+			channels: []discordgo.Channel{category, {ID: "2", ParentID: "1", PermissionOverwrites: []*discordgo.PermissionOverwrite{{ID: "50", Type: discordgo.PermissionOverwriteTypeRole}, nil}}},
+			messages: map[string][]discordgo.Message{"2": {
+				{ID: "20", ChannelID: "2"},
+				{ID: "21", ChannelID: "2", MessageReference: &discordgo.MessageReference{MessageID: "20"}},
+			}},
+		},
+		{
+			name:     "missing parent",
+			channels: []discordgo.Channel{{ID: "2", ParentID: "404"}},
+			want:     []IntegrityIssue{{Kind: IntegrityIssueChannelParent, ID: "2", Target: "404"}},
+		},
+		{
+			name:     "parent is not a category",
+			channels: []discordgo.Channel{{ID: "1"}, {ID: "2", ParentID: "1"}},
+			want:     []IntegrityIssue{{Kind: IntegrityIssueChannelParent, ID: "2", Target: "1"}},
+		},
+		{
+			name:     "overwrite for a missing role",
+			guild:    guild,
+			channels: []discordgo.Channel{{ID: "2", PermissionOverwrites: []*discordgo.PermissionOverwrite{{ID: "77", Type: discordgo.PermissionOverwriteTypeRole}}}},
+			want:     []IntegrityIssue{{Kind: IntegrityIssueOverwriteRole, ID: "2", Target: "77"}},
+		},
+		{
+			name:     "member overwrites are not checked without members",
+			channels: []discordgo.Channel{{ID: "2", PermissionOverwrites: []*discordgo.PermissionOverwrite{{ID: "7", Type: discordgo.PermissionOverwriteTypeMember}}}},
+		},
+		{
+			name:  "overwrite for a missing member",
+			guild: discordgo.Guild{Members: []*discordgo.Member{{User: &discordgo.User{ID: "7"}}, {}}},
+			channels: []discordgo.Channel{{ID: "2", PermissionOverwrites: []*discordgo.PermissionOverwrite{
+				{ID: "7", Type: discordgo.PermissionOverwriteTypeMember},
+				{ID: "8", Type: discordgo.PermissionOverwriteTypeMember},
+			}}},
+			want: []IntegrityIssue{{Kind: IntegrityIssueOverwriteMember, ID: "2", Target: "8"}},
+		},
+		{
+			name:     "messages of an unknown channel",
+			channels: []discordgo.Channel{{ID: "2"}},
+			messages: map[string][]discordgo.Message{"3": {{ID: "30"}}},
+			want:     []IntegrityIssue{{Kind: IntegrityIssueMessageChannelUnknown, ID: "3", Target: "3"}},
+		},
+		{
+			name:     "message stored under another channel",
+			channels: []discordgo.Channel{{ID: "2"}, {ID: "3"}},
+			messages: map[string][]discordgo.Message{"2": {{ID: "20", ChannelID: "3"}, {ID: "21"}}},
+			want:     []IntegrityIssue{{Kind: IntegrityIssueMessageChannelMismatch, ID: "20", Target: "3"}},
+		},
+		{
+			name:     "references across channels resolve",
+			channels: []discordgo.Channel{{ID: "2"}, {ID: "3"}},
+			messages: map[string][]discordgo.Message{
+				"2": {{ID: "20", MessageReference: &discordgo.MessageReference{MessageID: "30"}}},
+				"3": {{ID: "30"}, {ID: "31", MessageReference: &discordgo.MessageReference{MessageID: "404"}}, {ID: "32", MessageReference: &discordgo.MessageReference{}}},
+			},
+			want: []IntegrityIssue{{Kind: IntegrityIssueMessageReference, ID: "31", Target: "404"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core := &CoreBackupData{Guild: test.guild, Channels: test.channels, Messages: test.messages}
+
+			got := CheckIntegrity(core)
+			if !slices.Equal(got, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestSummarizeIntegrity(t *testing.T) {
+	summary := SummarizeIntegrity([]IntegrityIssue{
+		{Kind: IntegrityIssueMessageReference, ID: "1", Target: "2"},
+		{Kind: IntegrityIssueMessageReference, ID: "3", Target: "4"},
+		{Kind: IntegrityIssueChannelParent, ID: "5", Target: "6"},
+	})
+
+	if len(summary) != 2 || summary[IntegrityIssueMessageReference] != 2 || summary[IntegrityIssueChannelParent] != 1 {
+		t.Fatalf("unexpected summary %v", summary)
+	}
+}
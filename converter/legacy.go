@@ -0,0 +1,148 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anti-raid/legacybackupconverter/iblfile"
+	"github.com/bwmarrin/discordgo"
+)
+
+// A decrypted legacy (iblfile) backup along with its parsed metadata
+type LegacyBackup struct {
+	File     *iblfile.AutoEncryptedFile_FullFile
+	Sections map[string]*bytes.Buffer
+	Meta     *iblfile.Meta
+
+	// Whether the backup was encrypted with a password
+	Encrypted bool
+}
+
+// Opens and decrypts a legacy backup, checking that it is a server backup in a supported format version
+func OpenLegacyBackup(data []byte, password string) (*LegacyBackup, error) {
+	return OpenLegacyBackupContext(context.Background(), data, password)
+}
+
+// Like OpenLegacyBackup, but returns ErrCanceled as soon as ctx is done, even during key derivation and decryption
+func OpenLegacyBackupContext(ctx context.Context, data []byte, password string) (*LegacyBackup, error) {
+	var aes256src = iblfile.AES256Source{}
+	var noencryptsrc = iblfile.NoEncryptionSource{}
+
+	// The block is validated up front so that a decryption failure can only be caused by a wrong password
+	block, err := iblfile.ParseAutoEncryptedFileBlock(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	_, err = runContext(ctx, func() (struct{}, error) {
+		return struct{}{}, block.Validate()
+	})
+	if errors.Is(err, ErrCanceled) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	var encryptor iblfile.AutoEncryptor
+	switch string(block.Encryptor) {
+	case noencryptsrc.ID():
+		encryptor = noencryptsrc
+	case aes256src.ID():
+		if password == "" {
+			return nil, ErrPasswordRequired
+		}
+		aes256src.EncryptionKey = password
+		encryptor = &aes256src
+	default:
+		return nil, fmt.Errorf("%w: unknown encryptor: %s", ErrUnsupportedBackup, block.Encryptor)
+	}
+
+	// Key derivation (Argon2) and decryption cannot be interrupted, so they run in the background
+	f, err := runContext(ctx, func() (*iblfile.AutoEncryptedFile_FullFile, error) {
+		return iblfile.OpenAutoEncryptedFile_FullFile(bytes.NewReader(data), encryptor)
+	})
+	if errors.Is(err, ErrCanceled) {
+		return nil, err
+	}
+	if err != nil {
+		if encryptor == &aes256src {
+			return nil, ErrWrongPassword
+		}
+
+		return nil, fmt.Errorf("%w: failed to open autoencrypted file for conversion: %w", ErrInvalidBackup, err)
+	}
+
+	sections, err := runContext(ctx, f.Sections)
+	if errors.Is(err, ErrCanceled) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read sections: %w", ErrInvalidBackup, err)
+	}
+
+	meta, err := iblfile.ParseMetadata(sections)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse metadata: %w", ErrInvalidBackup, err)
+	}
+
+	if meta.Type != "backup.server" {
+		return nil, fmt.Errorf("%w: invalid file type: %s, please contact support for more information", ErrUnsupportedBackup, meta.Type)
+	}
+
+	if meta.FormatVersion != "a1" {
+		return nil, fmt.Errorf("%w: invalid file format version: %s, please contact support for more information", ErrUnsupportedBackup, meta.FormatVersion)
+	}
+
+	return &LegacyBackup{
+		File:      f,
+		Sections:  sections,
+		Meta:      meta,
+		Encrypted: encryptor == &aes256src,
+	}, nil
+}
+
+// Returns true if data looks like a legacy (iblfile) backup rather than an ARB1 backup
+func IsLegacyBackup(data []byte) bool {
+	return bytes.HasPrefix(data, iblfile.AutoEncryptedFileMagic)
+}
+
+// Returns the backup options the legacy backup was created with
+func (l *LegacyBackup) Options() (*OldBackupCreateOpts, error) {
+	return readMsgpackSection[OldBackupCreateOpts](l.File, "backup_opts")
+}
+
+// Returns the guild (including channels) stored in the legacy backup
+func (l *LegacyBackup) Guild() (*discordgo.Guild, error) {
+	return readMsgpackSection[discordgo.Guild](l.File, "core/guild")
+}
+
+// Returns the messages stored for a channel, or nil if the channel has no message section
+func (l *LegacyBackup) Messages(channelID string) ([]*BackupMessage, error) {
+	if _, ok := l.Sections["messages/"+channelID]; !ok {
+		return nil, nil
+	}
+
+	bm, err := readMsgpackSection[[]*BackupMessage](l.File, "messages/"+channelID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return *bm, nil
+}
+
+// Returns the IDs of all channels that have a message section, regardless of whether the channel still exists in the guild
+func (l *LegacyBackup) MessageChannels() []string {
+	var ids []string
+	for name := range l.Sections {
+		if id, ok := strings.CutPrefix(name, "messages/"); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
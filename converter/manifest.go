@@ -0,0 +1,292 @@
+package converter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// The name of the manifest entry inside an ARB1 tar
+const ManifestName = "manifest.json"
+
+// The current ARB1 format version written by the converter
+//
+// Version 0 is the original manifest-less output
+const FormatVersion = 1
+
+// The name of this converter as recorded in manifests
+const ProducerName = "legacybackupconverter"
+
+// The version of this converter, overridable at build time with
+// -ldflags "-X github.com/anti-raid/legacybackupconverter/converter.Version=..."
+var Version = "dev"
+
+// The manifest of an ARB1 backup, declaring the format it was written in
+type Manifest struct {
+	FormatVersion int              `json:"format_version"`
+	Producer      ManifestProducer `json:"producer"`
+	Source        *ManifestSource  `json:"source,omitempty"`
+
+	// The codec of the JSON entries. Empty for backups written before codecs were selectable, which always use gzip
+	Codec Codec `json:"codec,omitempty"`
+
+	// Optional layout features used by this backup, see supportedFeatures
+	Features []string `json:"features,omitempty"`
+
+	// When the legacy backup was converted to this format
+	ConvertedAt *time.Time `json:"converted_at,omitempty"`
+
+	// Size and SHA-256 of every other entry in the tar, in tar order
+	Entries []ManifestEntry `json:"entries,omitempty"`
+
+	// SHA-256 over the entry list, see ComputeDigest
+	Digest string `json:"digest,omitempty"`
+}
+
+// The checksum of a single tar entry
+type ManifestEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// The program that produced an ARB1 backup
+type ManifestProducer struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// The legacy file a backup was converted from
+type ManifestSource struct {
+	Protocol      string `json:"protocol"`
+	Type          string `json:"type"`
+	FormatVersion string `json:"format_version"`
+
+	// When the original (legacy) backup was taken
+	CreatedAt time.Time `json:"created_at"`
+
+	// Extra metadata attributes of the legacy file
+	ExtraMetadata map[string]string `json:"extra_metadata,omitempty"`
+}
+
+// Message authors are hoisted into CoreBackupData.Users and replaced by references
+const FeatureDeduplicatedAuthors = "deduplicated_authors"
+
+// Messages are stored per channel in messages/{channel_id}.json.gz entries instead of in core.json.gz
+const FeatureSplitMessages = "split_messages"
+
+// JSON entries use the codec recorded in the manifest (and its extension) instead of gzip
+const FeatureEntryCodec = "entry_codec"
+
+// Layout features this converter can read
+var supportedFeatures = map[string]bool{
+	FeatureDeduplicatedAuthors: true,
+	FeatureSplitMessages:       true,
+	FeatureEntryCodec:          true,
+}
+
+// Whether the manifest declares a feature
+func (m *Manifest) HasFeature(feature string) bool {
+	return slices.Contains(m.Features, feature)
+}
+
+// Creates the manifest for a backup converted from a legacy backup at convertedAt
+//
+// A zero convertedAt omits the conversion time, keeping the manifest reproducible
+func NewManifest(legacy *LegacyBackup, convertedAt time.Time) *Manifest {
+	manifest := &Manifest{
+		FormatVersion: FormatVersion,
+		Producer: ManifestProducer{
+			Name:    ProducerName,
+			Version: Version,
+		},
+		Source: &ManifestSource{
+			Protocol:      legacy.Meta.Protocol,
+			Type:          legacy.Meta.Type,
+			FormatVersion: legacy.Meta.FormatVersion,
+			CreatedAt:     legacy.Meta.CreatedAt.UTC(),
+			ExtraMetadata: legacy.Meta.ExtraMetadata,
+		},
+	}
+
+	if !convertedAt.IsZero() {
+		convertedAt = convertedAt.UTC()
+		manifest.ConvertedAt = &convertedAt
+	}
+
+	return manifest
+}
+
+// Computes the whole-archive digest of a manifest
+//
+// The digest is the hex SHA-256 of one "<sha256> <size> <name>\n" line per entry
+// in archive order, so it covers the content, size, name and order of every entry.
+// Readers enforce the order (see verifyOrder) as well as the checksums
+func (m *Manifest) ComputeDigest() string {
+	h := sha256.New()
+	for _, e := range m.Entries {
+		fmt.Fprintf(h, "%s %d %s\n", e.SHA256, e.Size, e.Name)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Checks the entries of a backup against the checksums in its manifest
+//
+// names lists the entries of the backup as read, including any duplicates, and entries holds their contents.
+// Every entry other than the manifest itself and its signature must be listed with a matching size and SHA-256.
+// No entry may appear more than once or have a name that is not local (see filepath.IsLocal)
+func (m *Manifest) VerifyChecksums(names []string, entries map[string]*bytes.Buffer) error {
+	if len(m.Entries) == 0 {
+		return fmt.Errorf("manifest has no checksums")
+	}
+
+	if m.ComputeDigest() != m.Digest {
+		return fmt.Errorf("manifest digest mismatch: expected %s, got %s", m.Digest, m.ComputeDigest())
+	}
+
+	var listed = make(map[string]bool, len(m.Entries))
+	for _, e := range m.Entries {
+		if !filepath.IsLocal(e.Name) {
+			return fmt.Errorf("entry %s listed in the manifest is not a local name", e.Name)
+		}
+
+		if listed[e.Name] {
+			return fmt.Errorf("entry %s is listed in the manifest more than once", e.Name)
+		}
+		listed[e.Name] = true
+
+		entry, ok := entries[e.Name]
+
+		if !ok {
+			return fmt.Errorf("entry %s is listed in the manifest but missing from the backup", e.Name)
+		}
+
+		if int64(entry.Len()) != e.Size {
+			return fmt.Errorf("entry %s has size %d, expected %d", e.Name, entry.Len(), e.Size)
+		}
+
+		sum := sha256.Sum256(entry.Bytes())
+
+		if hex.EncodeToString(sum[:]) != e.SHA256 {
+			return fmt.Errorf("entry %s has an invalid checksum", e.Name)
+		}
+	}
+
+	var seen = make(map[string]bool, len(names))
+	for _, name := range names {
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("entry %s is not a local name", name)
+		}
+
+		if seen[name] {
+			return fmt.Errorf("entry %s appears in the backup more than once", name)
+		}
+		seen[name] = true
+
+		if name != ManifestName && name != SignatureName && !listed[name] {
+			return fmt.Errorf("entry %s is not listed in the manifest", name)
+		}
+	}
+
+	return nil
+}
+
+// Checks that the entries of an archive, listed in names as read, are in the order of the manifest
+//
+// The manifest must be the first entry and a signature, if any, the last
+func (m *Manifest) verifyOrder(names []string) error {
+	if len(names) == 0 || names[0] != ManifestName {
+		return fmt.Errorf("%s is not the first entry of the backup", ManifestName)
+	}
+
+	names = names[1:]
+	if n := len(names); n > 0 && names[n-1] == SignatureName {
+		names = names[:n-1]
+	}
+
+	if len(names) != len(m.Entries) {
+		return fmt.Errorf("backup has %d entries, the manifest lists %d", len(names), len(m.Entries))
+	}
+
+	for i, e := range m.Entries {
+		if names[i] != e.Name {
+			return fmt.Errorf("entry %d of the backup is %s, the manifest lists %s", i+1, names[i], e.Name)
+		}
+	}
+
+	return nil
+}
+
+// Migrations upgrading a backup from the keyed format version to the next one
+var backupMigrations = map[int]func(b *Backup) error{
+	// Version 0 backups have no manifest, but are otherwise identical to version 1
+	0: func(b *Backup) error {
+		b.Manifest.FormatVersion = 1
+		return nil
+	},
+}
+
+// Reads the manifest of a backup, treating a missing manifest as format version 0
+func readManifest(entries map[string]*bytes.Buffer) (*Manifest, error) {
+	entry, ok := entries[ManifestName]
+
+	if !ok {
+		return &Manifest{FormatVersion: 0}, nil
+	}
+
+	var manifest Manifest
+
+	err := json.Unmarshal(entry.Bytes(), &manifest)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode %s: %w", ErrInvalidBackup, ManifestName, err)
+	}
+
+	return &manifest, nil
+}
+
+// Upgrades a backup in-memory to the current format version, refusing versions newer than this reader supports
+func migrateBackup(b *Backup) error {
+	for _, feature := range b.Manifest.Features {
+		if !supportedFeatures[feature] {
+			return fmt.Errorf("%w: unknown feature %q (produced by %s %s)", ErrUnsupportedBackup, feature, b.Manifest.Producer.Name, b.Manifest.Producer.Version)
+		}
+	}
+
+	if b.Manifest.Codec != "" {
+		if _, err := ParseCodec(string(b.Manifest.Codec)); err != nil {
+			return fmt.Errorf("%w: unknown codec %q", ErrUnsupportedBackup, b.Manifest.Codec)
+		}
+	}
+
+	if b.Manifest.FormatVersion > FormatVersion {
+		return fmt.Errorf("%w: format version %d (this converter supports up to %d, produced by %s %s)", ErrUnsupportedBackup, b.Manifest.FormatVersion, FormatVersion, b.Manifest.Producer.Name, b.Manifest.Producer.Version)
+	}
+
+	for b.Manifest.FormatVersion < FormatVersion {
+		migration, ok := backupMigrations[b.Manifest.FormatVersion]
+
+		if !ok {
+			return fmt.Errorf("%w: no migration available for format version %d", ErrUnsupportedBackup, b.Manifest.FormatVersion)
+		}
+
+		from := b.Manifest.FormatVersion
+
+		err := migration(b)
+
+		if err != nil {
+			return fmt.Errorf("failed to migrate backup from format version %d: %w", from, err)
+		}
+
+		if b.Manifest.FormatVersion <= from {
+			return fmt.Errorf("internal error: migration from format version %d did not advance the version", from)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package converter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVerifyChecksums(t *testing.T) {
+	f := &TarFile{sections: []tarSection{
+		{name: "core.json.gz", data: []byte("core")},
+		{name: "attachments/1", data: []byte("attachment")},
+	}}
+
+	manifest := &Manifest{}
+	_, err := f.EncodeManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		entries []tarSection
+		err     string
+	}{
+		{"ok", []tarSection{{ManifestName, nil}, {"core.json.gz", []byte("core")}, {"attachments/1", []byte("attachment")}}, ""},
+		{"signed", []tarSection{{ManifestName, nil}, {"core.json.gz", []byte("core")}, {"attachments/1", []byte("attachment")}, {SignatureName, nil}}, ""},
+		{"missing", []tarSection{{ManifestName, nil}, {"core.json.gz", []byte("core")}}, "missing from the backup"},
+		{"modified", []tarSection{{ManifestName, nil}, {"core.json.gz", []byte("CORE")}, {"attachments/1", []byte("attachment")}}, "invalid checksum"},
+		{"unlisted", []tarSection{{ManifestName, nil}, {"core.json.gz", []byte("core")}, {"attachments/1", []byte("attachment")}, {"extra", nil}}, "not listed"},
+		{"shadowed duplicate", []tarSection{{ManifestName, nil}, {"core.json.gz", []byte("forged")}, {"core.json.gz", []byte("core")}, {"attachments/1", []byte("attachment")}}, "more than once"},
+		{"not local", []tarSection{{ManifestName, nil}, {"core.json.gz", []byte("core")}, {"attachments/1", []byte("attachment")}, {"../escape", nil}}, "not a local name"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var names []string
+			entries := make(map[string]*bytes.Buffer)
+			for _, s := range test.entries {
+				names = append(names, s.name)
+				entries[s.name] = bytes.NewBuffer(s.data)
+			}
+
+			err := manifest.VerifyChecksums(names, entries)
+			if test.err == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksumsRejectsNonLocalListedNames(t *testing.T) {
+	f := &TarFile{sections: []tarSection{{name: "/etc/passwd", data: []byte("x")}}}
+
+	manifest := &Manifest{}
+	_, err := f.EncodeManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = manifest.VerifyChecksums([]string{ManifestName, "/etc/passwd"}, map[string]*bytes.Buffer{
+		ManifestName:  bytes.NewBuffer(nil),
+		"/etc/passwd": bytes.NewBufferString("x"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "not a local name") {
+		t.Fatalf("expected a non-local name error, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package converter
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Sorts messages by snowflake ID (oldest first) and removes duplicate IDs, returning the number of duplicates removed
+//
+// Retries in the legacy backup job could store the same message more than once. Of each
+// set of duplicates, the most complete copy (the one with the largest JSON encoding) is kept
+func NormalizeMessages(messages []discordgo.Message) ([]discordgo.Message, int) {
+	slices.SortStableFunc(messages, func(a, b discordgo.Message) int {
+		return compareSnowflakes(a.ID, b.ID)
+	})
+
+	var outp = messages[:0]
+	var removed int
+	for _, msg := range messages {
+		if len(outp) == 0 || outp[len(outp)-1].ID != msg.ID {
+			outp = append(outp, msg)
+			continue
+		}
+
+		removed++
+
+		if messageCompleteness(msg) > messageCompleteness(outp[len(outp)-1]) {
+			outp[len(outp)-1] = msg
+		}
+	}
+
+	return outp, removed
+}
+
+func messageCompleteness(msg discordgo.Message) int {
+	data, err := json.Marshal(msg)
+
+	if err != nil {
+		return 0
+	}
+
+	return len(data)
+}
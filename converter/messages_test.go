@@ -0,0 +1,64 @@
+package converter
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestNormalizeMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []discordgo.Message
+		want     []discordgo.Message
+		removed  int
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "sorted oldest first",
+			messages: []discordgo.Message{{ID: "3"}, {ID: "1"}, {ID: "2"}},
+			want:     []discordgo.Message{{ID: "1"}, {ID: "2"}, {ID: "3"}},
+		},
+		{
+			name:     "snowflakes compare numerically",
+			messages: []discordgo.Message{{ID: "100"}, {ID: "99"}, {ID: "1000"}},
+			want:     []discordgo.Message{{ID: "99"}, {ID: "100"}, {ID: "1000"}},
+		},
+		{
+			name:     "duplicates removed",
+			messages: []discordgo.Message{{ID: "2"}, {ID: "1"}, {ID: "2"}, {ID: "2"}},
+			want:     []discordgo.Message{{ID: "1"}, {ID: "2"}},
+			removed:  2,
+		},
+		{
+			name:     "most complete copy kept",
+			messages: []discordgo.Message{{ID: "1"}, {ID: "1", Content: "full"}, {ID: "1", Content: "a"}},
+			want:     []discordgo.Message{{ID: "1", Content: "full"}},
+			removed:  2,
+		},
+		{
+			name:     "first copy kept on a tie",
+			messages: []discordgo.Message{{ID: "1", Content: "a"}, {ID: "1", Content: "b"}},
+			want:     []discordgo.Message{{ID: "1", Content: "a"}},
+			removed:  1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, removed := NormalizeMessages(test.messages)
+			if removed != test.removed {
+				t.Errorf("expected %d duplicates removed, got %d", test.removed, removed)
+			}
+
+			if !slices.EqualFunc(got, test.want, func(a, b discordgo.Message) bool {
+				return a.ID == b.ID && a.Content == b.Content
+			}) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -12,7 +12,7 @@ func readMsgpackSection[T any](f *iblfile.AutoEncryptedFile_FullFile, name strin
 	section, err := f.Get(name)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get section %s: %w", name, err)
+		return nil, fmt.Errorf("%w: failed to get section %s: %w", ErrInvalidBackup, name, err)
 	}
 
 	dec := msgpack.NewDecoder(bytes.NewReader(section.Bytes()))
@@ -24,7 +24,7 @@ func readMsgpackSection[T any](f *iblfile.AutoEncryptedFile_FullFile, name strin
 	err = dec.Decode(&outp)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode section %s: %w", name, err)
+		return nil, fmt.Errorf("%w: failed to decode section %s: %w", ErrInvalidBackup, name, err)
 	}
 
 	return &outp, nil
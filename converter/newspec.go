@@ -4,19 +4,28 @@ package converter
 The file format for backups v2:
 
 Internally a backup is a TAR file with the .arb1 file extension. Encrypted backups are simply a AES256 encrypted ARB1 with the .arb1e file extension.
+The same entries may also be stored in a zip file (with stored, uncompressed entries) or unpacked into a directory tree, mainly for debugging and tooling.
 
 TAR File Contents:
-- `core.json`: A JSON file containing the cote backup data.
+- `manifest.json`: The (uncompressed) manifest declaring the format version, the producer (converter name and version), the legacy source of the backup (including when it was originally taken) and when it was converted. Backups without a manifest are format version 0.
+  The manifest also lists the size and SHA-256 of every other entry along with a whole-archive digest over that list, which readers check on open.
+  Optional layout features used by the backup are listed in `features`; readers must refuse backups using features they do not know.
+- `manifest.json.sig`: Optional raw Ed25519 signature over the exact bytes of `manifest.json`. As the manifest contains the checksums of all other entries, this signs the entire backup.
+- `core.json.gz`: A gzipped JSON file containing the core backup data. If the manifest declares another `codec` (with the `entry_codec` feature), this and the per-channel message entries use that codec's extension instead (`.zst` for zstd, none for uncompressed).
 - `assets/{asset_name}.jpg`: A directory containing all assets that are backed up, such as guild icons (and maybe emojis in the future?).
+- `messages/{channel_id}.json.gz`: The messages of a single channel as a gzipped JSON array, if the `split_messages` feature is used. `messages` in `core.json` is then empty. Channel IDs (like attachment IDs below) are always numeric snowflakes, and readers reject any other entry under `messages/`.
+- `attachments/{attachment_id}`: The files of message attachments, if attachments were kept and the legacy backup contained their bytes.
 
 ## Core Backup Data Format
 
 The JSON file contains the following fields:
 - `guild`: The guild object from Discord (a `discordTypes.GuildObject`)
 - `channels`: The channels in the guild, as an array of `discordTypes.ChannelObject` (this is a subset of the channels that were backed up).
-- `messages`: An array of messages (`discordTypes.MessageObject`).
-- `options`: The options used to create the backup, as defined in `BackupCreateOpts`.
+- `messages`: A map of channel IDs to an array of messages (`discordTypes.MessageObject`), sorted by message ID (oldest first) with no duplicate IDs.
+- `options`: The options used to create the backup, as defined in `BackupCreateOpts`. Options of the legacy backup without a new spec equivalent are kept in `options.legacy`.
 - `channel_allocation`: The final channel allocation for the backup, mapping channel IDs to the number of messages backed up in that channel.
+- `users`: Optional map of user IDs to users, present if the `deduplicated_authors` feature is used. Message authors identical to their entry in this table are then stored as `{"id": ..., "ref": true}` references, while authors without the `ref` marker are complete users.
+- `attachment_files`: Optional map of attachment IDs (as found in the `attachments` of messages) to the tar entry holding the attachment's file.
 */
 
 import "github.com/bwmarrin/discordgo"
@@ -27,6 +36,8 @@ type CoreBackupData struct {
 	Messages          map[string][]discordgo.Message `json:"messages"`
 	Options           BackupCreateOpts               `json:"options"`
 	ChannelAllocation map[string]int                 `json:"channel_allocation"`
+	AttachmentFiles   map[string]string              `json:"attachment_files,omitempty"`
+	Users             map[string]discordgo.User      `json:"users,omitempty"`
 }
 
 type BackupCreateOpts struct {
@@ -36,4 +47,14 @@ type BackupCreateOpts struct {
 	BackupMessages     bool           `json:"backupMessages"`
 	BackupGuildAssets  []string       `json:"backupGuildAssets"`  // "icon", "banner", "splash"
 	SpecialAllocations map[string]int `json:"specialAllocations"` // Specific channel allocation overrides
+
+	// Options of a converted legacy backup that have no equivalent in the new spec
+	Legacy *LegacyBackupCreateOpts `json:"legacy,omitempty"`
+}
+
+// Legacy backup options retained as-is for the restore side and auditors
+type LegacyBackupCreateOpts struct {
+	IgnoreMessageBackupErrors bool     `json:"ignoreMessageBackupErrors"`
+	RolloverLeftovers         bool     `json:"rolloverLeftovers"`
+	BackupGuildAssets         []string `json:"backupGuildAssets"` // The original asset names, including ones not carried over to the new spec
 }
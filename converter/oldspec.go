@@ -37,6 +37,8 @@ func hashmap[K comparable, V any](v map[K]V) map[K]V {
 
 func (opts *OldBackupCreateOpts) ToNew() BackupCreateOpts {
 	// Remove any assets not 'icon', 'banner', or 'splash' from backupGuildAssets
+	//
+	// The original list is kept in the legacy options
 	var validAssets = []string{}
 
 	for _, asset := range opts.BackupGuildAssets {
@@ -57,5 +59,10 @@ func (opts *OldBackupCreateOpts) ToNew() BackupCreateOpts {
 		BackupMessages:     opts.BackupMessages,
 		BackupGuildAssets:  array(validAssets),
 		SpecialAllocations: hashmap(opts.SpecialAllocations),
+		Legacy: &LegacyBackupCreateOpts{
+			IgnoreMessageBackupErrors: opts.IgnoreMessageBackupErrors,
+			RolloverLeftovers:         opts.RolloverLeftovers,
+			BackupGuildAssets:         array(opts.BackupGuildAssets),
+		},
 	}
 }
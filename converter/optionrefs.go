@@ -0,0 +1,76 @@
+package converter
+
+import "fmt"
+
+// A channel ID referenced by the backup options that does not exist in the backup's channels
+type DanglingReference struct {
+	Option    string `json:"option"` // "channels" or "specialAllocations"
+	ChannelID string `json:"channel_id"`
+	Pruned    bool   `json:"pruned"`
+}
+
+func (d DanglingReference) String() string {
+	var status string
+	if d.Pruned {
+		status = " (pruned)"
+	}
+
+	return fmt.Sprintf("options.%s references unknown channel %s%s", d.Option, d.ChannelID, status)
+}
+
+// Finds channel IDs in the backup options that do not exist in the backup's channels
+func CheckOptionReferences(core *CoreBackupData) []DanglingReference {
+	return optionReferences(core, false)
+}
+
+// Removes channel IDs in the backup options that do not exist in the backup's channels, returning what was found
+//
+// As an empty channels option means all channels, options.channels is left untouched if
+// every entry in it is dangling. Such references are reported with Pruned set to false
+func PruneOptionReferences(core *CoreBackupData) []DanglingReference {
+	return optionReferences(core, true)
+}
+
+func optionReferences(core *CoreBackupData, prune bool) []DanglingReference {
+	var known = make(map[string]bool, len(core.Channels))
+	for _, channel := range core.Channels {
+		known[channel.ID] = true
+	}
+
+	return optionReferencesTo(core, known, prune)
+}
+
+// Like optionReferences, with the channel IDs that count as existing given by known
+func optionReferencesTo(core *CoreBackupData, known map[string]bool, prune bool) []DanglingReference {
+	var refs []DanglingReference
+
+	var kept []string
+	for _, id := range core.Options.Channels {
+		if known[id] {
+			kept = append(kept, id)
+		} else {
+			refs = append(refs, DanglingReference{Option: "channels", ChannelID: id})
+		}
+	}
+
+	if prune && len(kept) > 0 && len(kept) < len(core.Options.Channels) {
+		core.Options.Channels = kept
+		for i := range refs {
+			refs[i].Pruned = true
+		}
+	}
+
+	for _, id := range sortedKeys(core.Options.SpecialAllocations) {
+		if known[id] {
+			continue
+		}
+
+		if prune {
+			delete(core.Options.SpecialAllocations, id)
+		}
+
+		refs = append(refs, DanglingReference{Option: "specialAllocations", ChannelID: id, Pruned: prune})
+	}
+
+	return refs
+}
@@ -0,0 +1,81 @@
+package converter
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestOptionReferences(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         BackupCreateOpts
+		prune        bool
+		want         []DanglingReference
+		wantChannels []string
+		wantSpecial  map[string]int
+	}{
+		{
+			name:         "no dangling references",
+			opts:         BackupCreateOpts{Channels: []string{"1", "2"}, SpecialAllocations: map[string]int{"1": 5}},
+			wantChannels: []string{"1", "2"},
+			wantSpecial:  map[string]int{"1": 5},
+		},
+		{
+			name: "check only reports",
+			opts: BackupCreateOpts{Channels: []string{"1", "9"}, SpecialAllocations: map[string]int{"8": 5, "2": 1, "7": 3}},
+			want: []DanglingReference{
+				{Option: "channels", ChannelID: "9"},
+				{Option: "specialAllocations", ChannelID: "7"},
+				{Option: "specialAllocations", ChannelID: "8"},
+			},
+			wantChannels: []string{"1", "9"},
+			wantSpecial:  map[string]int{"8": 5, "2": 1, "7": 3},
+		},
+		{
+			name:  "prune removes dangling references",
+			opts:  BackupCreateOpts{Channels: []string{"1", "9"}, SpecialAllocations: map[string]int{"8": 5, "2": 1}},
+			prune: true,
+			want: []DanglingReference{
+				{Option: "channels", ChannelID: "9", Pruned: true},
+				{Option: "specialAllocations", ChannelID: "8", Pruned: true},
+			},
+			wantChannels: []string{"1"},
+			wantSpecial:  map[string]int{"2": 1},
+		},
+		{
+			name:  "prune keeps channels if all are dangling",
+			opts:  BackupCreateOpts{Channels: []string{"8", "9"}},
+			prune: true,
+			want: []DanglingReference{
+				{Option: "channels", ChannelID: "8"},
+				{Option: "channels", ChannelID: "9"},
+			},
+			wantChannels: []string{"8", "9"},
+		},
+		{
+			name:  "empty channels means all channels",
+			opts:  BackupCreateOpts{},
+			prune: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core := &CoreBackupData{Channels: testChannels("1", "2"), Options: test.opts}
+
+			got := optionReferences(core, test.prune)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+
+			if !slices.Equal(core.Options.Channels, test.wantChannels) {
+				t.Errorf("expected channels %v, got %v", test.wantChannels, core.Options.Channels)
+			}
+
+			if !maps.Equal(core.Options.SpecialAllocations, test.wantSpecial) {
+				t.Errorf("expected special allocations %v, got %v", test.wantSpecial, core.Options.SpecialAllocations)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package converter
+
+import (
+	"crypto/ed25519"
+	"log/slog"
+	"time"
+)
+
+// Options controlling how a legacy backup is converted
+//
+// The zero value converts with default behaviour and no conversion time recorded
+type ConvertOptions struct {
+	// The conversion time recorded in the manifest
+	//
+	// If zero, no conversion time is recorded so that converting the same legacy
+	// backup always produces byte-for-byte identical output
+	ConvertedAt time.Time
+
+	// Prune messages exceeding the allocation implied by the backup options
+	// instead of only reporting them
+	EnforceAllocations bool
+
+	// Remove channel IDs that do not exist in the backup from the channels and
+	// specialAllocations options instead of only reporting them
+	PruneDanglingOptions bool
+
+	// Keep attachment metadata (filename, size, content type, dimensions, URL) on messages
+	// and store any attachment files present in the legacy backup as attachments/{id} entries
+	KeepAttachments bool
+
+	// Hoist message authors into a top-level users table, replacing them with references
+	// (the deduplicated_authors feature). OpenBackup reconstitutes the full messages
+	DedupAuthors bool
+
+	// Store each channel's messages in its own messages/{channel_id}.json.gz entry, keeping
+	// only guild, channels and options in core.json.gz (the split_messages feature)
+	SplitMessages bool
+
+	// The codec of the JSON entries, gzip if empty. Any codec other than gzip changes the
+	// entry extensions and is declared with the entry_codec feature
+	Codec Codec
+
+	// The compression level passed to the codec, 0 for the codec's default
+	CompressionLevel int
+
+	// The container format of the output, tar if empty
+	Format OutputFormat
+
+	// The directory the backup is unpacked into with the dir format. It must not exist or be empty
+	OutputDir string
+
+	// Leave out all messages. The backup options are updated to record that messages were not backed up
+	SkipMessages bool
+
+	// Leave out the guild icon, banner and splash. The backup options are updated to record that no assets were backed up
+	SkipAssets bool
+
+	// If not empty, only these channels (and their messages) are kept
+	IncludeChannels []string
+
+	// Channels (and their messages) to leave out, applied after IncludeChannels
+	//
+	// Channels left out this way still count as existing for the dangling option reference checks.
+	// Filters leaving no channels fail with ErrInvalidOptions
+	ExcludeChannels []string
+
+	// Keep at most this many of the newest messages per channel, 0 for no limit
+	MaxMessagesPerChannel int
+
+	// Refuse legacy backups larger than this many bytes with ErrLimitExceeded, 0 for no limit
+	MaxInputSize int64
+
+	// Refuse conversions whose entries add up to more than this many bytes with ErrLimitExceeded, 0 for no limit
+	MaxOutputSize int64
+
+	// If set, the output is encrypted with this password (an .arb1e backup, see DecryptBackup).
+	// Not supported with the dir format
+	EncryptionPassword string
+
+	// If set, the manifest is signed with this key and the signature stored as manifest.json.sig
+	SigningKey ed25519.PrivateKey
+
+	// Called as conversion progresses (see Progress), may be nil. It is called from the
+	// converting goroutine, so it should return quickly
+	Progress func(Progress)
+
+	// Receives a debug event, with the guild and channel IDs involved, for everything skipped
+	// or transformed while converting. nil discards them
+	Logger *slog.Logger
+}
+
+// The output of a conversion along with everything noteworthy found while converting
+type ConvertResult struct {
+	// The ARB1 backup (nil with the dir format, which writes to ConvertOptions.OutputDir)
+	Data []byte `json:"-"`
+
+	// Number of duplicate messages removed while normalizing message order
+	DuplicateMessages int `json:"duplicate_messages"`
+
+	// Channel IDs referenced by the backup options that do not exist in the backup
+	DanglingReferences []DanglingReference `json:"dangling_references"`
+
+	// Inconsistencies between the messages present and the backup options
+	AllocationIssues []AllocationIssue `json:"allocation_issues"`
+
+	// Broken references between channels, roles and messages
+	IntegrityIssues []IntegrityIssue `json:"integrity_issues"`
+
+	// Number of messages removed by EnforceAllocations
+	PrunedMessages int `json:"pruned_messages"`
+
+	// Number of message authors replaced by references into the users table
+	DedupedAuthors int `json:"deduped_authors"`
+
+	// Size of the (compressed) core.json entry in bytes
+	CoreSize int `json:"core_size"`
+
+	// Number of old messages removed by MaxMessagesPerChannel
+	TruncatedMessages int `json:"truncated_messages"`
+
+	// The signature of the manifest, if signed with ConvertOptions.SigningKey
+	Signature []byte `json:"-"`
+}
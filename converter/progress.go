@@ -0,0 +1,83 @@
+package converter
+
+// The stage of a conversion reported to ConvertOptions.Progress
+//
+// Stages are reported in the order below, skipping stages that do not apply (e.g. encrypt
+// without an encryption password). Every stage starts with Done = 0 and ends with Done = Total
+type ProgressStage string
+
+const (
+	// Validating, decrypting and unpacking the legacy backup (Total 1, bytes of the legacy backup)
+	ProgressDecrypt ProgressStage = "decrypt"
+
+	// Decoding the backup options and guild (Total 2, bytes of the decoded sections)
+	ProgressParse ProgressStage = "parse"
+
+	// Decoding the messages of each channel (Total channels, bytes of the message sections)
+	ProgressMessages ProgressStage = "messages"
+
+	// Copying guild assets and attachment files (Total files, bytes copied)
+	ProgressAssets ProgressStage = "assets"
+
+	// Encoding and compressing core.json and any per-channel message entries (Total entries, compressed bytes)
+	ProgressEncode ProgressStage = "encode"
+
+	// Writing the entries to the output (Total entries, bytes written)
+	ProgressWrite ProgressStage = "write"
+
+	// Encrypting the output (Total 1, bytes of the output)
+	ProgressEncrypt ProgressStage = "encrypt"
+)
+
+// A progress update of a conversion
+//
+// Progress is JSON encodable so that it can be forwarded as is to other processes
+type Progress struct {
+	Stage ProgressStage `json:"stage"`
+
+	// Number of items of the stage done so far, out of Total
+	Done  int `json:"done"`
+	Total int `json:"total"`
+
+	// Bytes processed by the stage so far and in total, TotalBytes is 0 if not known in advance
+	Bytes      int64 `json:"bytes"`
+	TotalBytes int64 `json:"total_bytes,omitempty"`
+
+	// The channel being processed in the messages stage
+	ChannelID string `json:"channel_id,omitempty"`
+}
+
+// Calls the Progress callback, if any
+func (o *ConvertOptions) report(p Progress) {
+	if o.Progress != nil {
+		o.Progress(p)
+	}
+}
+
+// An ArchiveWriter reporting the write stage for every entry
+type progressArchiveWriter struct {
+	w          ArchiveWriter
+	opts       *ConvertOptions
+	total      int
+	totalBytes int64
+
+	done  int
+	bytes int64
+}
+
+func (p *progressArchiveWriter) WriteEntry(name string, data []byte) error {
+	err := p.w.WriteEntry(name, data)
+
+	if err != nil {
+		return err
+	}
+
+	p.done++
+	p.bytes += int64(len(data))
+	p.opts.report(Progress{Stage: ProgressWrite, Done: p.done, Total: p.total, Bytes: p.bytes, TotalBytes: p.totalBytes})
+	return nil
+}
+
+func (p *progressArchiveWriter) Close() error {
+	return p.w.Close()
+}
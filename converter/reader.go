@@ -0,0 +1,324 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// A parsed ARB1 (new format) backup
+type Backup struct {
+	// Raw entries keyed by name
+	Entries map[string]*bytes.Buffer
+
+	// The manifest of the backup, migrated to the current format version
+	Manifest *Manifest
+
+	// The decoded core backup data (core.json), with any deduplicated authors inflated back into full messages
+	Core *CoreBackupData
+
+	// See ReadOptions
+	maxDecodedSize int64
+}
+
+// The total size the JSON entries of a backup may decompress to unless set in ReadOptions
+const DefaultMaxDecodedSize = 1 << 30
+
+// Options controlling how an ARB1 backup is read
+type ReadOptions struct {
+	// Refuse backups whose JSON entries decompress to more than this many bytes in total with
+	// ErrLimitExceeded, as well as archives whose entries (inflated, for zip files) add up to more.
+	// 0 uses DefaultMaxDecodedSize and a negative value disables the limit
+	MaxDecodedSize int64
+}
+
+func (o ReadOptions) maxDecodedSize() int64 {
+	switch {
+	case o.MaxDecodedSize < 0:
+		return math.MaxInt64
+	case o.MaxDecodedSize == 0:
+		return DefaultMaxDecodedSize
+	default:
+		return o.MaxDecodedSize
+	}
+}
+
+// Reads an ARB1 backup from its tar (or zip) bytes
+func OpenBackup(data []byte) (*Backup, error) {
+	return OpenBackupWithOptions(data, ReadOptions{})
+}
+
+// Like OpenBackup, with options such as the decoded size limit
+func OpenBackupWithOptions(data []byte, opts ReadOptions) (*Backup, error) {
+	sections, _, err := readArchive(data, opts.maxDecodedSize())
+
+	if errors.Is(err, ErrLimitExceeded) {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read backup: %w", ErrInvalidBackup, err)
+	}
+
+	names := make([]string, 0, len(sections))
+	entries := make(map[string]*bytes.Buffer, len(sections))
+	for _, s := range sections {
+		names = append(names, s.name)
+		entries[s.name] = bytes.NewBuffer(s.data)
+	}
+
+	return openBackup(names, entries, true, opts)
+}
+
+// Reads an ARB1 backup unpacked into a directory (see OutputDir)
+func OpenBackupDir(dir string) (*Backup, error) {
+	entries, err := readDir(dir)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	// Files in a directory have no order to check
+	return openBackup(slices.Sorted(maps.Keys(entries)), entries, false, ReadOptions{})
+}
+
+// Opens a backup from its entries, with names listing them as read (duplicates included)
+//
+// If ordered, names are in archive order, which must match the manifest
+func openBackup(names []string, entries map[string]*bytes.Buffer, ordered bool, opts ReadOptions) (*Backup, error) {
+	manifest, err := readManifest(entries)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Only format version 0 backups have no manifest, any manifest must list the checksums of all
+	// entries so that stripping the list cannot bypass the checks
+	if _, ok := entries[ManifestName]; ok {
+		if ordered {
+			err = manifest.verifyOrder(names)
+
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrIntegrity, err)
+			}
+		}
+
+		err = manifest.VerifyChecksums(names, entries)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrIntegrity, err)
+		}
+	}
+
+	b := &Backup{
+		Entries:        entries,
+		Manifest:       manifest,
+		maxDecodedSize: opts.maxDecodedSize(),
+	}
+
+	err = migrateBackup(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dedup := b.Manifest.HasFeature(FeatureDeduplicatedAuthors)
+	coreName := b.Codec().EntryName("core.json")
+	remaining := b.maxDecodedSize
+
+	data, err := readEntry(b.Entries, coreName, b.Codec(), &remaining)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b.Core, err = decodeJsonEntry[CoreBackupData](data, coreName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if dedup {
+		err = resolveCoreAuthorRefs(b.Core, data)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to resolve authors of %s: %w", ErrInvalidBackup, coreName, err)
+		}
+	}
+
+	if b.Manifest.HasFeature(FeatureSplitMessages) {
+		if b.Core.Messages == nil {
+			b.Core.Messages = make(map[string][]discordgo.Message)
+		}
+
+		for name := range b.Entries {
+			if _, ok := splitMessagesChannel(name, b.Codec()); strings.HasPrefix(name, "messages/") && !ok {
+				return nil, fmt.Errorf("%w: %s is not a messages/{channel_id}%s entry with a snowflake channel ID", ErrInvalidBackup, name, b.Codec().EntryName(".json"))
+			}
+		}
+
+		for _, channelID := range b.SplitMessageChannels() {
+			name := splitMessagesEntry(channelID, b.Codec())
+
+			data, err := readEntry(b.Entries, name, b.Codec(), &remaining)
+
+			if err != nil {
+				return nil, err
+			}
+
+			messages, err := decodeJsonEntry[[]discordgo.Message](data, name)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if dedup {
+				refs, err := decodeJsonEntry[[]authorRefs](data, name)
+
+				if err != nil {
+					return nil, err
+				}
+
+				err = resolveAuthorRefs(b.Core, channelID, *messages, *refs)
+
+				if err != nil {
+					return nil, fmt.Errorf("%w: failed to resolve authors of %s: %w", ErrInvalidBackup, name, err)
+				}
+			}
+
+			b.Core.Messages[channelID] = *messages
+		}
+	}
+
+	if dedup {
+		InflateAuthors(b.Core)
+	}
+
+	return b, nil
+}
+
+// Returns the codec of the backup's JSON entries
+func (b *Backup) Codec() Codec {
+	if b.Manifest.Codec == "" {
+		return CodecGzip
+	}
+
+	return b.Manifest.Codec
+}
+
+// Returns the IDs of the channels stored in messages/{channel_id}.json[.ext] entries (with the split_messages feature)
+func (b *Backup) SplitMessageChannels() []string {
+	var ids []string
+	for name := range b.Entries {
+		if id, ok := splitMessagesChannel(name, b.Codec()); ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Returns the channel ID of a messages/{channel_id}.json[.ext] entry, which must be a snowflake
+func splitMessagesChannel(name string, codec Codec) (string, bool) {
+	id, ok := strings.CutPrefix(name, "messages/")
+	if !ok {
+		return "", false
+	}
+
+	id, ok = strings.CutSuffix(id, codec.EntryName(".json"))
+	if !ok || !isSnowflake(id) {
+		return "", false
+	}
+
+	return id, true
+}
+
+func splitMessagesEntry(channelID string, codec Codec) string {
+	return codec.EntryName("messages/" + channelID + ".json")
+}
+
+// Validates core.json (and any per-channel message entries) against the CoreBackupData JSON Schema
+func (b *Backup) ValidateSchema() error {
+	remaining := b.maxDecodedSize
+
+	data, err := readEntry(b.Entries, b.Codec().EntryName("core.json"), b.Codec(), &remaining)
+
+	if err != nil {
+		return err
+	}
+
+	err = ValidateCoreBackupData(data)
+
+	if err != nil {
+		return err
+	}
+
+	if !b.Manifest.HasFeature(FeatureSplitMessages) {
+		return nil
+	}
+
+	coreSchema := CoreBackupDataSchema()
+	messagesSchema := &Schema{
+		Type:  "array",
+		Items: &Schema{Ref: "#/$defs/discordgo.Message"},
+		Defs:  coreSchema.Defs,
+	}
+
+	for _, channelID := range b.SplitMessageChannels() {
+		data, err := readEntry(b.Entries, splitMessagesEntry(channelID, b.Codec()), b.Codec(), &remaining)
+
+		if err != nil {
+			return err
+		}
+
+		err = ValidateSchema(messagesSchema, data)
+
+		if err != nil {
+			return fmt.Errorf("messages of channel %s failed schema validation: %w", channelID, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the decompressed contents of an entry, deducting their size from the remaining decoded size
+func readEntry(entries map[string]*bytes.Buffer, name string, codec Codec, remaining *int64) ([]byte, error) {
+	entry, ok := entries[name]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: no entry found for %s", ErrInvalidBackup, name)
+	}
+
+	data, err := codec.Decode(entry.Bytes(), *remaining)
+
+	if errors.Is(err, ErrLimitExceeded) {
+		return nil, fmt.Errorf("failed to decompress entry %s: %w", name, err)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decompress entry %s: %w", ErrInvalidBackup, name, err)
+	}
+
+	*remaining -= int64(len(data))
+	return data, nil
+}
+
+// Decodes the decompressed contents of an entry
+func decodeJsonEntry[T any](data []byte, name string) (*T, error) {
+	var outp T
+
+	err := json.Unmarshal(data, &outp)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode entry %s: %w", ErrInvalidBackup, name, err)
+	}
+
+	return &outp, nil
+}
@@ -0,0 +1,323 @@
+package converter
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/internal/legacytest"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Writes sections as a tar archive as they are, without adding a manifest
+func writeTestArchive(t *testing.T, sections []tarSection) []byte {
+	t.Helper()
+
+	buf := bytes.NewBuffer([]byte{})
+	w := NewTarArchiveWriter(buf, time.Time{})
+	for _, s := range sections {
+		err := w.WriteEntry(s.name, s.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestOpenBackupRejectsTamperedArchives(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ConvertFileWithOptions(legacytest.Backup(t, 3, ""), "", ConvertOptions{SigningKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sections, _, err := readArchive(res.Data, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core := slices.IndexFunc(sections, func(s tarSection) bool { return s.name == "core.json.gz" })
+	if core < 2 || sections[0].name != ManifestName || sections[len(sections)-1].name != SignatureName {
+		t.Fatalf("unexpected layout: %v", sections)
+	}
+	forged := tarSection{name: "core.json.gz", data: []byte("forged")}
+
+	tests := []struct {
+		name     string
+		sections []tarSection
+		err      error
+	}{
+		{"unchanged", sections, nil},
+		{"forged duplicate in front", slices.Insert(slices.Clone(sections), 1, forged), ErrInvalidBackup},
+		{"forged duplicate at the end", append(slices.Clone(sections), forged), ErrInvalidBackup},
+		{"manifest not first", append(slices.Clone(sections[1:]), sections[0]), ErrIntegrity},
+		{"entries reordered", slices.Concat(sections[:1], sections[core:core+1], sections[1:core], sections[core+1:]), ErrIntegrity},
+		{"signature not last", slices.Concat(sections[:1], sections[len(sections)-1:], sections[1:len(sections)-1]), ErrIntegrity},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := OpenBackup(writeTestArchive(t, test.sections))
+			if test.err == nil && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestSignBackupRejectsDuplicateEntries(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ConvertFile(legacytest.Backup(t, 3, ""), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sections, _, err := readArchive(data, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = SignBackup(writeTestArchive(t, append(sections, sections[1])), key)
+	if err == nil {
+		t.Fatal("expected signing an archive with duplicate entries to fail")
+	}
+}
+
+func TestOpenBackupRejectsInvalidSplitMessageEntries(t *testing.T) {
+	for _, name := range []string{"messages/100.json.gz", "messages/abc.json.gz", "messages/1/../../../tmp/pwned.json.gz", "messages/100.json"} {
+		t.Run(name, func(t *testing.T) {
+			f := NewTarFile()
+
+			err := f.WriteJsonGzSection(CoreBackupData{Channels: testChannels("100")}, "core.json.gz")
+			if err == nil {
+				err = f.WriteJsonGzSection([]discordgo.Message{{ID: "1", ChannelID: "100"}}, name)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf, err := f.BuildWithManifest(&Manifest{FormatVersion: FormatVersion, Features: []string{FeatureSplitMessages}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := OpenBackup(buf.Bytes())
+			if name == "messages/100.json.gz" {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(b.Core.Messages["100"]) != 1 {
+					t.Fatalf("expected the message of channel 100, got %v", b.Core.Messages)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrInvalidBackup) && !errors.Is(err, ErrIntegrity) {
+				t.Fatalf("expected the backup to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenBackupRequiresManifestEntries(t *testing.T) {
+	res, err := ConvertFileWithOptions(legacytest.Backup(t, 3, ""), "", ConvertOptions{SplitMessages: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sections, _, err := readArchive(res.Data, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(sections[0].data, &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest.Entries = nil
+	manifest.Digest = ""
+	stripped, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenBackup(writeTestArchive(t, append([]tarSection{{name: ManifestName, data: stripped}}, sections[1:]...)))
+	if !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("expected %v for a manifest without entries, got %v", ErrIntegrity, err)
+	}
+
+	// Format version 0 backups have no manifest (nor split messages) at all
+	b, err := OpenBackup(writeTestArchive(t, []tarSection{sections[1], sections[2]}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Manifest.FormatVersion != FormatVersion {
+		t.Fatalf("expected a migrated backup, got format version %d", b.Manifest.FormatVersion)
+	}
+}
+
+// Returns a tar archive whose only entry is a PAX (GNU format 1.0) sparse file of realSize bytes
+// made of a single hole
+func sparseTestArchive(t *testing.T, realSize int64) []byte {
+	t.Helper()
+
+	var records []byte
+	for _, kv := range [][2]string{
+		{"GNU.sparse.major", "1"},
+		{"GNU.sparse.minor", "0"},
+		{"GNU.sparse.name", "core.json"},
+		{"GNU.sparse.realsize", strconv.FormatInt(realSize, 10)},
+	} {
+		record := " " + kv[0] + "=" + kv[1] + "\n"
+		n := len(record)
+		n += len(strconv.Itoa(n + len(strconv.Itoa(n))))
+		records = append(records, strconv.Itoa(n)+record...)
+	}
+
+	// The sparse map (no data blocks) padded to a block, as stored at the start of the entry
+	sparseMap := make([]byte, 512)
+	copy(sparseMap, "0\n")
+
+	buf := bytes.NewBuffer([]byte{})
+	w := tar.NewWriter(buf)
+	for _, e := range []struct {
+		name string
+		data []byte
+	}{{"PaxHeaders/core.json", records}, {"GNUSparseFile.0/core.json", sparseMap}} {
+		err := w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: e.name, Mode: 0600, Size: int64(len(e.data)), Format: tar.FormatUSTAR})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = w.Write(e.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Turn the first header into a PAX extended header, which tar.Writer refuses to write by hand
+	data := buf.Bytes()
+	data[156] = tar.TypeXHeader
+	copy(data[148:156], "        ")
+	var sum int64
+	for _, b := range data[:512] {
+		sum += int64(b)
+	}
+	copy(data[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	return data
+}
+
+func TestOpenBackupLimitsArchiveEntries(t *testing.T) {
+	zeros := bytes.Repeat([]byte{0}, 8<<20)
+
+	zipped := func(t *testing.T, uncompressedSize uint64) []byte {
+		deflated := bytes.NewBuffer([]byte{})
+		fw, err := flate.NewWriter(deflated, flate.BestCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fw.Write(zeros)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = fw.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := bytes.NewBuffer([]byte{})
+		w := zip.NewWriter(buf)
+		ew, err := w.CreateRaw(&zip.FileHeader{
+			Name:               "core.json",
+			Method:             zip.Deflate,
+			CRC32:              crc32.ChecksumIEEE(zeros),
+			CompressedSize64:   uint64(deflated.Len()),
+			UncompressedSize64: uncompressedSize,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ew.Write(deflated.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return buf.Bytes()
+	}
+
+	gnuSparse := bytes.NewBuffer([]byte{})
+	w := tar.NewWriter(gnuSparse)
+	err := w.WriteHeader(&tar.Header{Typeflag: tar.TypeGNUSparse, Name: "core.json", Format: tar.FormatGNU})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		limit int64
+		err   error
+	}{
+		{"zip entry over the limit", zipped(t, uint64(len(zeros))), 1 << 20, ErrLimitExceeded},
+		// archive/zip itself refuses to inflate entries past their stated size
+		{"zip entry understating its size", zipped(t, 100), 1 << 20, ErrInvalidBackup},
+		{"tar entry over the limit", writeTestArchive(t, []tarSection{{name: "core.json", data: zeros}}), 1 << 20, ErrLimitExceeded},
+		{"gnu sparse tar entry", gnuSparse.Bytes(), -1, ErrInvalidBackup},
+		{"pax sparse tar entry", sparseTestArchive(t, 1<<40), -1, ErrInvalidBackup},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := OpenBackupWithOptions(test.data, ReadOptions{MaxDecodedSize: test.limit})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package converter
+
+//go:generate go run ../cmd/schemagen -o ../schema/core.schema.json
+
+import (
+	"encoding"
+	"encoding/json"
+	"maps"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const SchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// A (subset of a) JSON Schema document
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"` // string or []string
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+
+	// Set for schemas that must not allow any extra properties (serialized as additionalProperties: false)
+	closed bool
+}
+
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type plain Schema
+
+	if !s.closed {
+		return json.Marshal((*plain)(s))
+	}
+
+	return json.Marshal(struct {
+		*plain
+		AdditionalProperties bool `json:"additionalProperties"`
+	}{
+		plain: (*plain)(s),
+	})
+}
+
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	type plain Schema
+
+	var raw struct {
+		plain
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	}
+
+	err := json.Unmarshal(data, &raw)
+
+	if err != nil {
+		return err
+	}
+
+	*s = Schema(raw.plain)
+
+	switch string(raw.AdditionalProperties) {
+	case "":
+	case "false":
+		s.closed = true
+	case "true":
+		s.AdditionalProperties = &Schema{}
+	default:
+		s.AdditionalProperties = &Schema{}
+		return json.Unmarshal(raw.AdditionalProperties, s.AdditionalProperties)
+	}
+
+	return nil
+}
+
+// Returns the JSON Schema describing core.json.gz, generated from CoreBackupData
+func CoreBackupDataSchema() *Schema {
+	schema := GenerateSchema(reflect.TypeFor[CoreBackupData](), "ARB1 core backup data")
+
+	// With deduplicated authors, a message author may also be a reference into the users table
+	userRef := GenerateSchema(reflect.TypeFor[UserRef](), "")
+	maps.Copy(schema.Defs, userRef.Defs)
+
+	if message, ok := schema.Defs["discordgo.Message"]; ok {
+		message.Properties["author"] = &Schema{AnyOf: []*Schema{
+			{Ref: "#/$defs/discordgo.User"},
+			{Ref: userRef.Ref},
+			{Type: "null"},
+		}}
+	}
+
+	return schema
+}
+
+// Generates a JSON Schema for a Go type from its encoding/json representation
+func GenerateSchema(t reflect.Type, title string) *Schema {
+	g := &schemaGenerator{defs: map[string]*Schema{}}
+	root := g.schemaFor(t)
+
+	return &Schema{
+		Schema: SchemaDraft,
+		Title:  title,
+		Ref:    root.Ref,
+		Defs:   g.defs,
+	}
+}
+
+type schemaGenerator struct {
+	defs map[string]*Schema
+}
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	rawMessageType    = reflect.TypeFor[json.RawMessage]()
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+func nullable(s *Schema) *Schema {
+	if s.Ref != "" {
+		return &Schema{AnyOf: []*Schema{s, {Type: "null"}}}
+	}
+
+	if typ, ok := s.Type.(string); ok {
+		s.Type = []string{typ, "null"}
+	}
+
+	return s
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &Schema{}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		// Custom JSON representation, we cannot know its shape
+		return &Schema{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Pointer:
+		return nullable(g.schemaFor(t.Elem()))
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Byte slices are encoded as base64 strings
+			return &Schema{Type: []string{"string", "null"}}
+		}
+
+		return &Schema{Type: []string{"array", "null"}, Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: []string{"object", "null"}, AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := t.String()
+
+		if _, ok := g.defs[name]; !ok {
+			def := &Schema{Type: "object", Properties: map[string]*Schema{}, closed: true}
+			g.defs[name] = def // Register before recursing to support self-referential types
+			g.addFields(def, t)
+		}
+
+		return &Schema{Ref: "#/$defs/" + name}
+	default:
+		// Channels, funcs etc. cannot be encoded at all
+		return &Schema{}
+	}
+}
+
+// Adds the JSON-visible fields of a struct to an object schema
+func (g *schemaGenerator) addFields(def *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				g.addFields(def, ft)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		var prop *Schema
+		if hasTagOption(opts, "string") && isStringableKind(field.Type.Kind()) {
+			prop = &Schema{Type: "string"}
+		} else {
+			prop = g.schemaFor(field.Type)
+		}
+
+		def.Properties[name] = prop
+
+		if !hasTagOption(opts, "omitempty") && !hasTagOption(opts, "omitzero") {
+			def.Required = append(def.Required, name)
+		}
+	}
+}
+
+func hasTagOption(opts, option string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+func isStringableKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,58 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/anti-raid/legacybackupconverter/internal/legacytest"
+)
+
+func TestCoreBackupDataSchemaUpToDate(t *testing.T) {
+	// Marshaled the same way as cmd/schemagen
+	schema, err := json.MarshalIndent(CoreBackupDataSchema(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema = append(schema, '\n')
+
+	published, err := os.ReadFile("../schema/core.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(schema, published) {
+		t.Fatal("schema/core.schema.json is out of date, run go generate ./...")
+	}
+}
+
+func TestConvertedBackupMatchesSchema(t *testing.T) {
+	for _, opts := range []ConvertOptions{
+		{},
+		{KeepAttachments: true, DedupAuthors: true},
+		{SplitMessages: true, Codec: CodecZstd},
+	} {
+		res, err := ConvertFileWithOptions(legacytest.Backup(t, 5, ""), "", opts)
+		if err != nil {
+			t.Fatalf("%+v: %s", opts, err)
+		}
+
+		b, err := OpenBackup(res.Data)
+		if err != nil {
+			t.Fatalf("%+v: %s", opts, err)
+		}
+
+		err = b.ValidateSchema()
+		if err != nil {
+			t.Errorf("%+v: %s", opts, err)
+		}
+	}
+}
+
+func TestValidateCoreBackupDataRejectsWrongTypes(t *testing.T) {
+	err := ValidateCoreBackupData([]byte(`{"guild": "not an object"}`))
+	if err == nil {
+		t.Fatal("expected a schema error")
+	}
+}
@@ -0,0 +1,236 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A single schema violation at a JSON path
+type SchemaError struct {
+	Path    string
+	Message string
+}
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// All schema violations found while validating a document
+type SchemaErrors []SchemaError
+
+func (e SchemaErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("document does not match schema (%d errors): %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Maximum number of violations collected before validation stops
+const maxSchemaErrors = 100
+
+// Validates a JSON document against a schema produced by GenerateSchema
+//
+// Only the subset of JSON Schema emitted by GenerateSchema is supported. Returns SchemaErrors on violations
+func ValidateSchema(schema *Schema, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc any
+
+	err := dec.Decode(&doc)
+
+	if err != nil {
+		return fmt.Errorf("failed to parse document: %w", err)
+	}
+
+	v := &schemaValidator{root: schema}
+	v.validate(schema, doc, "$")
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+
+	return nil
+}
+
+type schemaValidator struct {
+	root *Schema
+	errs SchemaErrors
+}
+
+func (v *schemaValidator) fail(path, format string, args ...any) {
+	if len(v.errs) < maxSchemaErrors {
+		v.errs = append(v.errs, SchemaError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+func (v *schemaValidator) resolve(ref string) (*Schema, error) {
+	name, ok := strings.CutPrefix(ref, "#/$defs/")
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref: %s", ref)
+	}
+
+	def, ok := v.root.Defs[name]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown $ref: %s", ref)
+	}
+
+	return def, nil
+}
+
+func (v *schemaValidator) validate(s *Schema, doc any, path string) {
+	if len(v.errs) >= maxSchemaErrors {
+		return
+	}
+
+	if s.Ref != "" {
+		def, err := v.resolve(s.Ref)
+
+		if err != nil {
+			v.fail(path, "%s", err)
+			return
+		}
+
+		v.validate(def, doc, path)
+		return
+	}
+
+	if len(s.AnyOf) > 0 {
+		for _, option := range s.AnyOf {
+			sub := &schemaValidator{root: v.root}
+			sub.validate(option, doc, path)
+
+			if len(sub.errs) == 0 {
+				return
+			}
+		}
+
+		v.fail(path, "value does not match any allowed schema")
+		return
+	}
+
+	if s.Type != nil && !matchesType(s.Type, doc) {
+		v.fail(path, "expected %v, got %s", s.Type, jsonTypeOf(doc))
+		return
+	}
+
+	switch doc := doc.(type) {
+	case string:
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, doc); err != nil {
+				v.fail(path, "invalid date-time: %q", doc)
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range doc {
+				v.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := doc[name]; !ok {
+				v.fail(path, "missing required property %q", name)
+			}
+		}
+
+		keys := make([]string, 0, len(doc))
+		for k := range doc {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			childPath := path + "." + k
+
+			if prop, ok := s.Properties[k]; ok {
+				v.validate(prop, doc[k], childPath)
+			} else if s.AdditionalProperties != nil {
+				v.validate(s.AdditionalProperties, doc[k], childPath)
+			} else if s.closed {
+				v.fail(path, "unexpected property %q", k)
+			}
+		}
+	}
+}
+
+func matchesType(typ any, doc any) bool {
+	switch typ := typ.(type) {
+	case string:
+		return matchesSingleType(typ, doc)
+	case []string:
+		for _, t := range typ {
+			if matchesSingleType(t, doc) {
+				return true
+			}
+		}
+		return false
+	case []any:
+		for _, t := range typ {
+			if t, ok := t.(string); ok && matchesSingleType(t, doc) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+func matchesSingleType(typ string, doc any) bool {
+	switch typ {
+	case "integer":
+		n, ok := doc.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		if err != nil {
+			// Large unsigned values do not fit in an int64
+			return !strings.ContainsAny(n.String(), ".eE-")
+		}
+		return true
+	case "number":
+		_, ok := doc.(json.Number)
+		return ok
+	default:
+		return jsonTypeOf(doc) == typ
+	}
+}
+
+func jsonTypeOf(doc any) string {
+	switch doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+// Validates a core.json document against the CoreBackupData schema
+func ValidateCoreBackupData(data []byte) error {
+	err := ValidateSchema(CoreBackupDataSchema(), data)
+
+	if err != nil {
+		return fmt.Errorf("core backup data failed schema validation: %w", err)
+	}
+
+	return nil
+}
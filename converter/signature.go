@@ -0,0 +1,184 @@
+package converter
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// The name of the entry holding the Ed25519 signature of the manifest
+//
+// The entry contains the raw 64 byte signature over the exact bytes of manifest.json
+const SignatureName = "manifest.json.sig"
+
+// Signs the manifest of an ARB1 backup (tar or zip), returning the backup with the signature
+// appended as an entry along with the raw (detached) signature
+func SignBackup(data []byte, key ed25519.PrivateKey) ([]byte, []byte, error) {
+	sections, modTime, err := readArchive(data, math.MaxInt64)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	var entries []tarSection
+	var manifest []byte
+	for _, s := range sections {
+		if s.name == SignatureName {
+			// Re-signing replaces the existing signature
+			continue
+		}
+
+		if s.name == ManifestName {
+			manifest = s.data
+		}
+
+		entries = append(entries, s)
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("backup has no manifest to sign")
+	}
+
+	sig := ed25519.Sign(key, manifest)
+
+	tarfile := &TarFile{
+		sections: append(entries, tarSection{name: SignatureName, data: sig}),
+		ModTime:  modTime,
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+
+	w, err := newArchiveWriter(detectOutputFormat(data), buf, "", modTime)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tarfile.WriteArchive(w, nil)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build signed backup: %w", err)
+	}
+
+	return buf.Bytes(), sig, nil
+}
+
+// Signs the manifest of an ARB1 backup unpacked into a directory, writing the signature
+// next to it and returning the raw (detached) signature
+func SignBackupDir(dir string, key ed25519.PrivateKey) ([]byte, error) {
+	manifestPath := filepath.Join(dir, ManifestName)
+
+	manifest, err := os.ReadFile(manifestPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	sig := ed25519.Sign(key, manifest)
+
+	err = os.WriteFile(filepath.Join(dir, SignatureName), sig, 0600)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to write signature: %w", err)
+	}
+
+	// Keep the signature's modification time in line with the rest of the backup
+	info, err := os.Stat(manifestPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chtimes(filepath.Join(dir, SignatureName), info.ModTime(), info.ModTime())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sig, nil
+}
+
+// Verifies the Ed25519 signature of the backup's manifest
+//
+// If detached is nil, the signature embedded in the backup is used. Returns ErrUnsigned if there is no signature
+func (b *Backup) VerifySignature(pub ed25519.PublicKey, detached []byte) error {
+	sig := detached
+
+	if sig == nil {
+		entry, ok := b.Entries[SignatureName]
+
+		if !ok {
+			return ErrUnsigned
+		}
+
+		sig = entry.Bytes()
+	}
+
+	manifest, ok := b.Entries[ManifestName]
+
+	if !ok {
+		return fmt.Errorf("%w: backup has no manifest, cannot verify signature", ErrIntegrity)
+	}
+
+	// A signature over a manifest without checksums would not cover the rest of the backup
+	if len(b.Manifest.Entries) == 0 {
+		return fmt.Errorf("%w: backup manifest has no checksums, cannot verify signature", ErrIntegrity)
+	}
+
+	if !ed25519.Verify(pub, manifest.Bytes(), sig) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Parses a PEM encoded PKCS #8 Ed25519 private key (as generated by `openssl genpkey -algorithm ed25519`)
+func ParseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+
+	if !ok {
+		return nil, fmt.Errorf("private key is not an Ed25519 key")
+	}
+
+	return edKey, nil
+}
+
+// Parses a PEM encoded PKIX Ed25519 public key (as generated by `openssl pkey -pubout`)
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in public key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	edKey, ok := key.(ed25519.PublicKey)
+
+	if !ok {
+		return nil, fmt.Errorf("public key is not an Ed25519 key")
+	}
+
+	return edKey, nil
+}
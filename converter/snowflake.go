@@ -0,0 +1,12 @@
+package converter
+
+import "strconv"
+
+// Whether id is a Discord snowflake (an unsigned 64-bit integer in decimal)
+//
+// IDs taken from legacy section names are used in entry names, so anything else is refused
+// rather than risking names escaping the backup (e.g. "../../etc")
+func isSnowflake(id string) bool {
+	_, err := strconv.ParseUint(id, 10, 64)
+	return err == nil
+}
@@ -0,0 +1,29 @@
+package converter
+
+import "testing"
+
+func TestIsSnowflake(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"0", true},
+		{"1234567890123456789", true},
+		{"18446744073709551615", true},
+		{"18446744073709551616", false},
+		{"", false},
+		{"-1", false},
+		{"+1", false},
+		{"1_000", false},
+		{"a10000003", false},
+		{"../../../tmp/pwned", false},
+		{"1/../2", false},
+		{" 1", false},
+	}
+
+	for _, test := range tests {
+		if got := isSnowflake(test.id); got != test.want {
+			t.Errorf("isSnowflake(%q) = %v, expected %v", test.id, got, test.want)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package converter
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"time"
 )
 
 type SourceParsed struct {
@@ -12,79 +14,195 @@ type SourceParsed struct {
 	Table string
 }
 
+// A section that has been added to a TarFile but not yet written
+type tarSection struct {
+	name string
+	data []byte
+}
+
+// An in-memory ARB1 tar file
+//
+// Sections are buffered until Build so that the manifest (which contains
+// the checksums of all other sections) can be written as the first entry.
+// WriteArchive writes the same sections to any ArchiveWriter (e.g. zip or a directory)
+//
+// Output is deterministic: entries are written in the order they were added
+// with fixed owner, mode and ModTime, and compressed entries carry no timestamp
 type TarFile struct {
-	tarWriter *tar.Writer
-	buf       *bytes.Buffer
+	sections []tarSection
+	names    map[string]bool
+
+	// The modification time recorded for every entry (truncated to seconds)
+	ModTime time.Time
 }
 
-// Returns the size of the file
+// Returns the total size of all sections added so far
 func (f *TarFile) Size() int {
-	return f.buf.Len()
+	var size int
+	for _, s := range f.sections {
+		size += len(s.data)
+	}
+	return size
 }
 
-func NewTarFile() *TarFile {
-	buf := bytes.NewBuffer([]byte{})
-	tarWriter := tar.NewWriter(buf)
-
-	return &TarFile{
-		buf:       buf,
-		tarWriter: tarWriter,
+// Returns the size of a section, or 0 if there is no such section
+func (f *TarFile) SectionSize(name string) int {
+	for _, s := range f.sections {
+		if s.name == name {
+			return len(s.data)
+		}
 	}
+	return 0
+}
+
+func NewTarFile() *TarFile {
+	return &TarFile{}
 }
 
 // Adds a section to a file
 func (f *TarFile) WriteSection(buf *bytes.Buffer, name string) error {
-	err := f.tarWriter.WriteHeader(&tar.Header{
-		Name: name,
-		Mode: 0600,
-		Size: int64(buf.Len()),
+	if f.names == nil {
+		f.names = make(map[string]bool)
+	}
+
+	if f.names[name] {
+		return fmt.Errorf("duplicate section: %s", name)
+	}
+
+	f.names[name] = true
+
+	f.sections = append(f.sections, tarSection{
+		name: name,
+		data: bytes.Clone(buf.Bytes()),
 	})
 
+	return nil
+}
+
+// Adds a section to a file with json file format, compressed with the given codec and level
+func (f *TarFile) WriteJsonSection(i any, name string, codec Codec, level int) error {
+	data, err := encodeJsonEntry(i, codec, level)
+
 	if err != nil {
 		return err
 	}
 
-	_, err = f.tarWriter.Write(buf.Bytes())
+	return f.WriteSection(bytes.NewBuffer(data), name)
+}
+
+// Returns the JSON encoding of a value, compressed with the given codec and level
+func encodeJsonEntry(i any, codec Codec, level int) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{})
+
+	err := json.NewEncoder(buf).Encode(i)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return codec.Encode(buf.Bytes(), level)
 }
 
-// Adds a section to a file with json file format
+// Adds a section to a file with gzipped json file format
 func (f *TarFile) WriteJsonGzSection(i any, name string) error {
+	return f.WriteJsonSection(i, name, CodecGzip, 0)
+}
+
+// Returns the size and SHA-256 of every section added so far, in order
+func (f *TarFile) Checksums() []ManifestEntry {
+	entries := make([]ManifestEntry, 0, len(f.sections))
+	for _, s := range f.sections {
+		sum := sha256.Sum256(s.data)
+		entries = append(entries, ManifestEntry{
+			Name:   s.name,
+			Size:   int64(len(s.data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	return entries
+}
+
+// Writes the tar file with the given manifest as its first entry
+//
+// The checksums of all sections are filled into the manifest before it is written
+func (f *TarFile) BuildWithManifest(manifest *Manifest) (*bytes.Buffer, error) {
 	buf := bytes.NewBuffer([]byte{})
 
-	err := json.NewEncoder(buf).Encode(i)
+	err := f.WriteArchive(NewTarArchiveWriter(buf, f.ModTime), manifest)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Gzip the buffer
-	gzippedBuf := bytes.NewBuffer([]byte{})
-	gzWriter := gzip.NewWriter(gzippedBuf)
-	_, err = gzWriter.Write(buf.Bytes())
+	return buf, nil
+}
+
+// Writes the tar file without a manifest
+func (f *TarFile) Build() (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer([]byte{})
+
+	err := f.WriteArchive(NewTarArchiveWriter(buf, f.ModTime), nil)
+
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = gzWriter.Close()
-	if err != nil {
-		return err
+
+	return buf, nil
+}
+
+// Writes all sections to an archive writer and closes it
+//
+// If manifest is not nil, the checksums of all sections are filled into it and it is written as the first entry
+func (f *TarFile) WriteArchive(w ArchiveWriter, manifest *Manifest) error {
+	var manifestBytes []byte
+	if manifest != nil {
+		var err error
+		manifestBytes, err = f.EncodeManifest(manifest)
+
+		if err != nil {
+			return err
+		}
 	}
-	return f.WriteSection(gzippedBuf, name)
+
+	return f.writeArchive(w, manifestBytes)
 }
 
-func (f *TarFile) Build() (*bytes.Buffer, error) {
-	// Close tar file
-	err := f.tarWriter.Close()
+// Fills the checksums of all sections into the manifest and returns its encoded form
+func (f *TarFile) EncodeManifest(manifest *Manifest) ([]byte, error) {
+	manifest.Entries = f.Checksums()
+	manifest.Digest = manifest.ComputeDigest()
+
+	manifestBuf := bytes.NewBuffer([]byte{})
+
+	enc := json.NewEncoder(manifestBuf)
+	enc.SetIndent("", "  ")
+
+	err := enc.Encode(manifest)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// Return the buffer
-	return f.buf, nil
+	return manifestBuf.Bytes(), nil
+}
+
+// Writes an encoded manifest (if not nil), all sections and then any trailing sections (e.g. a signature)
+func (f *TarFile) writeArchive(w ArchiveWriter, manifest []byte, trailing ...tarSection) error {
+	if manifest != nil {
+		err := w.WriteEntry(ManifestName, manifest)
+
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", ManifestName, err)
+		}
+	}
+
+	for _, s := range append(f.sections, trailing...) {
+		err := w.WriteEntry(s.name, s.data)
+
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", s.name, err)
+		}
+	}
+
+	return w.Close()
 }
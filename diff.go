@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+// Compares a legacy backup with its converted output, exiting with exitDifferences if they differ
+func runDiff(ctx context.Context, cmd *command, args []string) error {
+	fs := cmd.newFlagSet()
+	jsonOutput := fs.Bool("json", false, "Output the diff report as JSON")
+	convertedPassword := fs.String("converted-password", "", "Password of an encrypted (.arb1e) converted backup")
+
+	err := cmd.parse(fs, args, 2, 3)
+	if err != nil {
+		return err
+	}
+
+	if fs.Arg(0) == "-" && fs.Arg(1) == "-" {
+		return usageError("only one input can be read from stdin")
+	}
+
+	legacyBytes, err := readInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	legacy, err := converter.OpenLegacyBackupContext(ctx, legacyBytes, fs.Arg(2))
+	if err != nil {
+		return err
+	}
+
+	converted, err := openConverted(fs.Arg(1), *convertedPassword)
+	if err != nil {
+		return err
+	}
+
+	report, err := converter.Diff(legacy, converted)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+		if err != nil {
+			return err
+		}
+	} else {
+		printDiffReport(report)
+	}
+
+	if !report.Equal() {
+		return reportedExit(exitDifferences)
+	}
+
+	return nil
+}
+
+func printDiffReport(report *converter.DiffReport) {
+	fmt.Println("Messages:")
+	for _, c := range report.Channels {
+		status := "ok"
+		if !c.Equal() {
+			status = "MISMATCH"
+		}
+		fmt.Printf("  %s: legacy=%d (+%d duplicates) converted=%d [%s]\n", c.ChannelID, c.LegacyCount, c.LegacyDuplicates, c.ConvertedCount, status)
+		for _, id := range c.OnlyInLegacy {
+			fmt.Printf("    - %s (only in legacy)\n", id)
+		}
+		for _, id := range c.OnlyInConverted {
+			fmt.Printf("    + %s (only in converted)\n", id)
+		}
+	}
+
+	fmt.Println("Guild fields:")
+	for _, f := range report.GuildFields {
+		fmt.Printf("  %s: legacy=%s converted=%s\n", f.Field, f.Legacy, f.Converted)
+	}
+
+	fmt.Println("Roles:")
+	for _, r := range report.Roles {
+		switch {
+		case r.OnlyInLegacy:
+			fmt.Printf("  - %s (only in legacy)\n", r.RoleID)
+		case r.OnlyInConverted:
+			fmt.Printf("  + %s (only in converted)\n", r.RoleID)
+		default:
+			for _, f := range r.Fields {
+				fmt.Printf("  %s.%s: legacy=%s converted=%s\n", r.RoleID, f.Field, f.Legacy, f.Converted)
+			}
+		}
+	}
+
+	fmt.Println("Assets:")
+	for _, a := range report.Assets {
+		status := "equal"
+		if !a.Equal {
+			status = "DIFFERENT"
+		}
+		fmt.Printf("  %s -> %s: legacy=%d bytes converted=%d bytes [%s]\n", a.LegacyName, a.ConvertedName, a.LegacySize, a.ConvertedSize, status)
+	}
+
+	if report.Equal() {
+		fmt.Println("No differences found")
+	}
+}
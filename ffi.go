@@ -0,0 +1,114 @@
+//go:build ffi
+
+// The C interface of the converter, built as a shared library with
+//
+//	go build -tags ffi -buildmode=c-shared -o libarbconverter.so .
+//
+// which also writes the matching libarbconverter.h
+package main
+
+/*
+#include <stdlib.h>
+
+// Receives a progress update of a conversion as JSON (see converter.Progress) along with the
+// user data passed to ArbConvert. The string is only valid until the callback returns
+typedef void (*arb_progress_fn)(const char *progress, void *user_data);
+
+static inline void arb_call_progress(arb_progress_fn fn, const char *progress, void *user_data) {
+	fn(progress, user_data);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"unsafe"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+// Converts the legacy backup of input_len bytes at input, returning its exit code (see README)
+//
+// password may be NULL for unencrypted backups and options is NULL or a JSON object of the
+// options of the worker command. progress, if not NULL, is called with user_data as the
+// conversion progresses, from the calling thread. On success, *output and *output_len hold
+// the ARB1 backup and *result the conversion result as JSON, otherwise *result holds
+// {"error": ..., "class": ...}. Both are to be freed with ArbFree
+//
+//export ArbConvert
+func ArbConvert(input unsafe.Pointer, inputLen C.size_t, password *C.char, options *C.char, progress C.arb_progress_fn, userData unsafe.Pointer, output **C.char, outputLen *C.size_t, result **C.char) C.int {
+	*output = nil
+	*outputLen = 0
+
+	res, err := ffiConvert(input, inputLen, password, options, progress, userData)
+	if err != nil {
+		data, _ := json.Marshal(errorResponse{Error: err.Error(), Class: errorClass(err)})
+		*result = C.CString(string(data))
+		return C.int(exitCode(err))
+	}
+
+	data, err := json.Marshal(workerConvertResult{ConvertResult: res, Signature: res.Signature})
+	if err != nil {
+		data, _ = json.Marshal(errorResponse{Error: err.Error(), Class: errorClass(err)})
+		*result = C.CString(string(data))
+		return C.int(exitCode(err))
+	}
+
+	*output = (*C.char)(C.CBytes(res.Data))
+	*outputLen = C.size_t(len(res.Data))
+	*result = C.CString(string(data))
+	return C.int(exitOK)
+}
+
+// Frees memory returned by ArbConvert
+//
+//export ArbFree
+func ArbFree(p unsafe.Pointer) {
+	C.free(p)
+}
+
+func ffiConvert(input unsafe.Pointer, inputLen C.size_t, password *C.char, options *C.char, progress C.arb_progress_fn, userData unsafe.Pointer) (*converter.ConvertResult, error) {
+	if input == nil || inputLen == 0 {
+		return nil, usageError("empty input")
+	}
+
+	var chosen conversionOptions
+	if options != nil {
+		err := json.Unmarshal([]byte(C.GoString(options)), &chosen)
+		if err != nil {
+			return nil, usageError("invalid options: " + err.Error())
+		}
+	}
+
+	opts, err := chosen.convertOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Format == converter.OutputDir {
+		return nil, usageError("the dir output format is not supported through the FFI")
+	}
+
+	if progress != nil {
+		opts.Progress = func(p converter.Progress) {
+			data, err := json.Marshal(p)
+			if err != nil {
+				return
+			}
+
+			cs := C.CString(string(data))
+			defer C.free(unsafe.Pointer(cs))
+			C.arb_call_progress(progress, cs, userData)
+		}
+	}
+
+	var pw string
+	if password != nil {
+		pw = C.GoString(password)
+	}
+
+	data := bytes.Clone(unsafe.Slice((*byte)(input), inputLen))
+	return converter.ConvertFileContext(context.Background(), data, pw, opts)
+}
@@ -2,10 +2,13 @@ module github.com/anti-raid/legacybackupconverter
 
 go 1.25rc2
 
-require golang.org/x/crypto v0.41.0
+require (
+	github.com/bwmarrin/discordgo v0.29.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.41.0
+)
 
 require (
-	github.com/bwmarrin/discordgo v0.29.0 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 )
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+// A summary of a legacy or converted backup
+type inspectReport struct {
+	Kind     string              `json:"kind"` // legacy or arb1
+	Legacy   *legacyInfo         `json:"legacy,omitempty"`
+	Manifest *converter.Manifest `json:"manifest,omitempty"`
+	Entries  int                 `json:"entries"` // Sections of a legacy backup, entries of a converted one
+	Guild    guildInfo           `json:"guild"`
+	Channels []channelInfo       `json:"channels"`
+}
+
+type legacyInfo struct {
+	Type          string            `json:"type"`
+	Protocol      string            `json:"protocol"`
+	FormatVersion string            `json:"format_version"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Encrypted     bool              `json:"encrypted"`
+	ExtraMetadata map[string]string `json:"extra_metadata,omitempty"`
+}
+
+type guildInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// A channel of the backup. Name is empty for message sections of channels missing from the guild
+type channelInfo struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+}
+
+// Prints the metadata, guild and channels of a legacy or converted backup
+func runInspect(ctx context.Context, cmd *command, args []string) error {
+	fs := cmd.newFlagSet()
+	jsonOutput := fs.Bool("json", false, "Output the report as JSON")
+
+	err := cmd.parse(fs, args, 1, 2)
+	if err != nil {
+		return err
+	}
+
+	var report *inspectReport
+
+	if isDir(fs.Arg(0)) {
+		backup, err := converter.OpenBackupDir(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		report = convertedReport(backup)
+	} else {
+		data, err := readInput(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		report, err = inspectData(ctx, data, fs.Arg(1), converter.ReadOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	printInspectReport(report)
+	return nil
+}
+
+// Inspects a legacy backup or a (possibly encrypted) converted file, reading the latter with readOpts
+func inspectData(ctx context.Context, data []byte, password string, readOpts converter.ReadOptions) (*inspectReport, error) {
+	if converter.IsLegacyBackup(data) {
+		return inspectLegacy(ctx, data, password)
+	}
+
+	if password != "" {
+		var err error
+		data, err = converter.DecryptBackup(data, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	backup, err := converter.OpenBackupWithOptions(data, readOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertedReport(backup), nil
+}
+
+func inspectLegacy(ctx context.Context, data []byte, password string) (*inspectReport, error) {
+	legacy, err := converter.OpenLegacyBackupContext(ctx, data, password)
+	if err != nil {
+		return nil, err
+	}
+
+	guild, err := legacy.Guild()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &inspectReport{
+		Kind: "legacy",
+		Legacy: &legacyInfo{
+			Type:          legacy.Meta.Type,
+			Protocol:      legacy.Meta.Protocol,
+			FormatVersion: legacy.Meta.FormatVersion,
+			CreatedAt:     legacy.Meta.CreatedAt,
+			Encrypted:     legacy.Encrypted,
+			ExtraMetadata: legacy.Meta.ExtraMetadata,
+		},
+		Entries:  len(legacy.Sections),
+		Guild:    guildInfo{ID: guild.ID, Name: guild.Name},
+		Channels: []channelInfo{},
+	}
+
+	var names = make(map[string]string)
+	for _, channel := range guild.Channels {
+		if channel != nil {
+			names[channel.ID] = channel.Name
+		}
+	}
+
+	channelIDs := append(slices.Collect(maps.Keys(names)), legacy.MessageChannels()...)
+	slices.Sort(channelIDs)
+
+	for _, channelID := range slices.Compact(channelIDs) {
+		messages, err := legacy.Messages(channelID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get messages for channel %s: %w", channelID, err)
+		}
+
+		report.Channels = append(report.Channels, channelInfo{ID: channelID, Name: names[channelID], Messages: len(messages)})
+	}
+
+	return report, nil
+}
+
+func convertedReport(backup *converter.Backup) *inspectReport {
+	report := &inspectReport{
+		Kind:     "arb1",
+		Manifest: backup.Manifest,
+		Entries:  len(backup.Entries),
+		Guild:    guildInfo{ID: backup.Core.Guild.ID, Name: backup.Core.Guild.Name},
+		Channels: []channelInfo{},
+	}
+
+	var names = make(map[string]string)
+	var channelIDs []string
+	for _, channel := range backup.Core.Channels {
+		names[channel.ID] = channel.Name
+		channelIDs = append(channelIDs, channel.ID)
+	}
+	for channelID := range backup.Core.Messages {
+		channelIDs = append(channelIDs, channelID)
+	}
+	slices.Sort(channelIDs)
+
+	for _, channelID := range slices.Compact(channelIDs) {
+		report.Channels = append(report.Channels, channelInfo{ID: channelID, Name: names[channelID], Messages: len(backup.Core.Messages[channelID])})
+	}
+
+	return report
+}
+
+func printInspectReport(report *inspectReport) {
+	if report.Legacy != nil {
+		encryption := "unencrypted"
+		if report.Legacy.Encrypted {
+			encryption = "encrypted"
+		}
+
+		fmt.Printf("Legacy backup (%s %s, format %s, %s, %d sections)\n", report.Legacy.Type, report.Legacy.Protocol, report.Legacy.FormatVersion, encryption, report.Entries)
+		fmt.Printf("Backup taken on %s\n", report.Legacy.CreatedAt.Format(time.RFC1123))
+	}
+
+	if m := report.Manifest; m != nil {
+		fmt.Printf("ARB1 backup (format version %d, produced by %s %s, %d entries)\n", m.FormatVersion, m.Producer.Name, m.Producer.Version, report.Entries)
+
+		codec := m.Codec
+		if codec == "" {
+			codec = converter.CodecGzip
+		}
+		fmt.Printf("Codec: %s\n", codec)
+
+		if len(m.Features) > 0 {
+			fmt.Printf("Features: %s\n", strings.Join(m.Features, ", "))
+		}
+
+		if m.Source != nil {
+			fmt.Printf("Backup taken on %s (%s %s, format %s)\n", m.Source.CreatedAt.Format(time.RFC1123), m.Source.Type, m.Source.Protocol, m.Source.FormatVersion)
+		}
+
+		if m.ConvertedAt != nil {
+			fmt.Printf("Migrated on %s\n", m.ConvertedAt.Format(time.RFC1123))
+		}
+	}
+
+	fmt.Printf("Guild: %s (%s)\n", report.Guild.Name, report.Guild.ID)
+
+	var total int
+	fmt.Println("Channels:")
+	for _, c := range report.Channels {
+		name := c.Name
+		if name == "" {
+			name = "(unknown channel)"
+		}
+		fmt.Printf("  %s %s: %d messages\n", c.ID, name, c.Messages)
+		total += c.Messages
+	}
+	fmt.Printf("%d messages in %d channels\n", total, len(report.Channels))
+}
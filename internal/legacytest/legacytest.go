@@ -0,0 +1,175 @@
+// Package legacytest builds legacy (iblfile) backups for tests
+package legacytest
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/iblfile"
+	"github.com/bwmarrin/discordgo"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// A section (tar entry) of a legacy backup
+type Section struct {
+	Name string
+	Data []byte
+}
+
+// Encodes v like the legacy backups did, as msgpack using the json struct tags
+func Msgpack(tb testing.TB, v any) []byte {
+	tb.Helper()
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetSortMapKeys(true)
+
+	err := enc.Encode(v)
+	if err != nil {
+		tb.Fatalf("failed to encode msgpack: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+// Writes the sections as an autoencrypted file, encrypted with AES-256 if password is not empty
+func File(tb testing.TB, sections []Section, password string) []byte {
+	tb.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, section := range sections {
+		err := tw.WriteHeader(&tar.Header{Name: section.Name, Mode: 0600, Size: int64(len(section.Data))})
+		if err == nil {
+			_, err = tw.Write(section.Data)
+		}
+		if err != nil {
+			tb.Fatalf("failed to write section %s: %s", section.Name, err)
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		tb.Fatalf("failed to write tar: %s", err)
+	}
+
+	data := buf.Bytes()
+	encryptor := iblfile.NoEncryptionSource{}.ID()
+	if password != "" {
+		src := &iblfile.AES256Source{EncryptionKey: password}
+
+		data, err = src.Encrypt(data)
+		if err != nil {
+			tb.Fatalf("failed to encrypt: %s", err)
+		}
+
+		encryptor = src.ID()
+	}
+
+	sum := sha256.Sum256(data)
+
+	var out []byte
+	out = append(out, iblfile.AutoEncryptedFileMagic...)
+	out = append(out, sum[:]...)
+	out = append(out, encryptor...)
+	out = append(out, data...)
+	return out
+}
+
+// The sections of a small guild backup with the given number of messages in each of its two
+// backed up channels
+//
+// It deliberately contains what real backups contain and the converter has to cope with: a nil
+// channel, a channel whose parent is missing, an overwrite for a missing role, an option naming a
+// missing channel, a reply to a missing message and a message backed up twice
+func Sections(tb testing.TB, messages int) []Section {
+	tb.Helper()
+
+	meta, err := json.Marshal(iblfile.Meta{
+		CreatedAt:     time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Protocol:      iblfile.Protocol,
+		FormatVersion: "a1",
+		Type:          "backup.server",
+		ExtraMetadata: map[string]string{"k": "v"},
+	})
+	if err != nil {
+		tb.Fatalf("failed to encode meta: %s", err)
+	}
+
+	sections := []Section{
+		{Name: "meta", Data: meta},
+		{Name: "backup_opts", Data: Msgpack(tb, map[string]any{
+			"Channels":                  []string{"100", "999"},
+			"PerChannel":                3,
+			"MaxMessages":               100,
+			"BackupMessages":            true,
+			"BackupGuildAssets":         []string{"guildIcon", "emojis"},
+			"IgnoreMessageBackupErrors": true,
+			"RolloverLeftovers":         true,
+			"SpecialAllocations":        map[string]int{"101": 10, "555": 4},
+		})},
+		{Name: "core/guild", Data: Msgpack(tb, discordgo.Guild{
+			ID:      "1",
+			Name:    "Test",
+			OwnerID: "7",
+			Roles: []*discordgo.Role{
+				{ID: "1", Name: "@everyone", Permissions: 8},
+				{ID: "50", Name: "Mod", Color: 5},
+			},
+			Channels: []*discordgo.Channel{
+				{ID: "90", Name: "cat", Type: discordgo.ChannelTypeGuildCategory},
+				{ID: "100", Name: "general", ParentID: "90", PermissionOverwrites: []*discordgo.PermissionOverwrite{
+					{ID: "50", Type: discordgo.PermissionOverwriteTypeRole, Allow: 1024},
+					{ID: "77", Type: discordgo.PermissionOverwriteTypeRole},
+				}},
+				{ID: "101", Name: "other", ParentID: "404"},
+				nil,
+			},
+		})},
+	}
+
+	for _, channelID := range []string{"100", "101"} {
+		var msgs []map[string]*discordgo.Message
+		for i := range messages {
+			id := fmt.Sprintf("%s%05d", channelID, messages-i)
+			msg := &discordgo.Message{
+				ID:        id,
+				ChannelID: channelID,
+				Content:   "hello " + id,
+				Author:    &discordgo.User{ID: fmt.Sprint(7 + i%3), Username: fmt.Sprint("user", i%3), Avatar: "abcdef"},
+				Attachments: []*discordgo.MessageAttachment{
+					{ID: "9" + id, Filename: "f.png", Size: 10, URL: "https://cdn/x.png", ContentType: "image/png", Width: 2, Height: 2},
+				},
+			}
+			if i == 1 {
+				msg.MessageReference = &discordgo.MessageReference{MessageID: "404"}
+			}
+
+			msgs = append(msgs, map[string]*discordgo.Message{"message": msg})
+		}
+
+		if channelID == "100" && len(msgs) > 0 {
+			msgs = append(msgs, msgs[0])
+		}
+
+		sections = append(sections, Section{Name: "messages/" + channelID, Data: Msgpack(tb, msgs)})
+	}
+
+	return append(sections,
+		Section{Name: "attachments/910000003", Data: []byte("PNGDATA")},
+		Section{Name: "assets/guildIcon", Data: []byte("ICONBYTES")},
+		Section{Name: "assets/emojis", Data: []byte("EMOJI")},
+	)
+}
+
+// A legacy backup of the guild of Sections, encrypted with password if not empty
+func Backup(tb testing.TB, messages int, password string) []byte {
+	tb.Helper()
+	return File(tb, Sections(tb, messages), password)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+)
+
+// The -v, -q and --log-format flags of commands that log to stderr
+type logFlags struct {
+	verbose bool
+	quiet   bool
+	format  string
+}
+
+func (l *logFlags) register(flags *flag.FlagSet) {
+	flags.BoolVar(&l.verbose, "v", false, "Also log debug events, such as everything skipped or transformed while converting")
+	flags.BoolVar(&l.quiet, "q", false, "Only log errors, hiding warnings and summaries")
+	flags.StringVar(&l.format, "log-format", "text", "Format of the log on stderr (text or json)")
+}
+
+// Returns a logger writing to w at the verbosity and in the format selected by the flags
+func (l *logFlags) logger(w io.Writer) (*slog.Logger, error) {
+	if l.verbose && l.quiet {
+		return nil, usageError("-v and -q cannot be combined")
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case l.verbose:
+		level = slog.LevelDebug
+	case l.quiet:
+		level = slog.LevelError
+	}
+
+	switch l.format {
+	case "text":
+		// Timestamps only clutter a log read in a terminal, unlike one collected as JSON
+		return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})), nil
+	default:
+		return nil, usageError("unknown log format " + l.format + " (expected text or json)")
+	}
+}
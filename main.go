@@ -1,35 +1,303 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/signal"
+	"strings"
 
 	"github.com/anti-raid/legacybackupconverter/converter"
 )
 
+// Exit codes, documented in the README
+const (
+	exitOK                = 0
+	exitError             = 1  // Any error not covered below
+	exitUsage             = 2  // Invalid command, flags or arguments
+	exitIO                = 3  // An input or output file could not be read or written
+	exitPassword          = 4  // The legacy backup needs a password, or the password is wrong
+	exitInvalidBackup     = 5  // The input is not a backup, or is corrupt
+	exitUnsupportedBackup = 6  // The backup's type, format version, feature or codec is not supported
+	exitIntegrity         = 7  // Checksum, signature or schema verification failed
+	exitDifferences       = 8  // diff found differences
+	exitLimitExceeded     = 9  // A size or message limit was exceeded
+	exitCanceled          = 10 // Interrupted or timed out
+)
+
+// A subcommand of the CLI
+type command struct {
+	name    string
+	args    string // Synopsis of the positional arguments
+	summary string
+	run     func(ctx context.Context, cmd *command, args []string) error
+}
+
+var commands = []*command{
+	{
+		name:    "convert",
+		args:    "<path to legacy backup> <path to output file or directory> [<password>]",
+		summary: "Converts a legacy backup to the ARB1 format",
+		run:     runConvert,
+	},
+	{
+		name:    "inspect",
+		args:    "<path to legacy backup, converted file or directory> [<password>]",
+		summary: "Shows the metadata, guild and channels of a legacy or converted backup",
+		run:     runInspect,
+	},
+	{
+		name:    "verify",
+		args:    "<path to converted file or directory>",
+		summary: "Checks a converted backup against its checksums, the published schema and optionally a signature",
+		run:     runVerify,
+	},
+	{
+		name:    "diff",
+		args:    "<path to legacy backup> <path to converted file or directory> [<password>]",
+		summary: "Compares a legacy backup with its converted output",
+		run:     runDiff,
+	},
+	{
+		name:    "serve",
+		summary: "Serves an HTTP API to convert and inspect uploaded backups",
+		run:     runServe,
+	},
+	{
+		name:    "worker",
+		summary: "Handles JSON requests read line by line from stdin, writing JSON responses to stdout",
+		run:     runWorker,
+	},
+	{
+		name:    "batch",
+		args:    "<input directory> <output directory>",
+		summary: "Converts every legacy backup in a directory, resuming where a previous run left off",
+		run:     runBatch,
+	},
+}
+
+// An invalid command line, reported along with the usage of the command
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// An outcome that has already been reported to the user, exiting with the given code
+type reportedExit int
+
+func (e reportedExit) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
 func main() {
-	args := os.Args
-	if len(args) < 3 {
-		panic("Usage: legacybackupconverter <path to legacy backup> <path to output file> [<password>]")
+	// An interrupt cancels the running command, which then exits with exitCanceled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:])
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				return exitCode(cmd.run(ctx, cmd, []string{"--help"}))
+			}
+
+			fmt.Fprintf(os.Stderr, "legacybackupconverter: unknown command %q\n", args[1])
+			return exitUsage
+		}
+
+		printUsage(os.Stdout)
+		return exitOK
 	}
 
-	legacyBackupPath := args[1]
-	outputFilePath := args[2]
-	var password string
-	if len(args) > 3 {
-		password = args[3]
+	cmd := findCommand(args[0])
+	if cmd != nil {
+		args = args[1:]
+	} else {
+		// Converting is the default for backwards compatibility with `legacybackupconverter <legacy> <output>`
+		cmd = findCommand("convert")
 	}
 
-	fileBytes, err := os.ReadFile(legacyBackupPath)
-	if err != nil {
-		panic(err)
+	err := cmd.run(ctx, cmd, args)
+
+	var usageErr usageError
+	switch {
+	case err == nil:
+	case errors.As(err, new(reportedExit)):
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(os.Stderr, "legacybackupconverter %s: %s\n", cmd.name, usageErr)
+		fmt.Fprintf(os.Stderr, "Usage: legacybackupconverter %s [flags] %s\nRun 'legacybackupconverter help %s' for details\n", cmd.name, cmd.args, cmd.name)
+	default:
+		fmt.Fprintf(os.Stderr, "legacybackupconverter %s: %s\n", cmd.name, err)
 	}
 
-	data, err := converter.ConvertFile(fileBytes, password)
-	if err != nil {
-		panic(err)
+	return exitCode(err)
+}
+
+// Maps an error to the exit code of its class
+func exitCode(err error) int {
+	var reported reportedExit
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &reported):
+		return int(reported)
+	case errors.As(err, new(usageError)), errors.Is(err, converter.ErrInvalidOptions):
+		return exitUsage
+	case errors.Is(err, converter.ErrPasswordRequired), errors.Is(err, converter.ErrWrongPassword):
+		return exitPassword
+	case errors.Is(err, converter.ErrInvalidBackup):
+		return exitInvalidBackup
+	case errors.Is(err, converter.ErrUnsupportedBackup):
+		return exitUnsupportedBackup
+	case errors.Is(err, converter.ErrIntegrity), errors.Is(err, converter.ErrInvalidSignature),
+		errors.Is(err, converter.ErrUnsigned), errors.As(err, new(converter.SchemaErrors)):
+		return exitIntegrity
+	case errors.Is(err, converter.ErrLimitExceeded):
+		return exitLimitExceeded
+	case errors.Is(err, converter.ErrCanceled):
+		return exitCanceled
+	case errors.As(err, new(*fs.PathError)):
+		return exitIO
+	default:
+		return exitError
+	}
+}
+
+// Returns the name of the class of an error, as reported by the serve, worker and batch commands
+func errorClass(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, new(usageError)), errors.Is(err, converter.ErrInvalidOptions):
+		return "invalid_request"
+	case errors.Is(err, converter.ErrPasswordRequired):
+		return "password_required"
+	case errors.Is(err, converter.ErrWrongPassword):
+		return "wrong_password"
+	case errors.Is(err, converter.ErrInvalidBackup):
+		return "invalid_backup"
+	case errors.Is(err, converter.ErrUnsupportedBackup):
+		return "unsupported_backup"
+	case errors.Is(err, converter.ErrIntegrity), errors.Is(err, converter.ErrInvalidSignature),
+		errors.Is(err, converter.ErrUnsigned), errors.As(err, new(converter.SchemaErrors)):
+		return "integrity"
+	case errors.Is(err, converter.ErrLimitExceeded):
+		return "limit_exceeded"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, converter.ErrCanceled):
+		return "canceled"
+	case errors.As(err, new(*fs.PathError)):
+		return "io"
+	default:
+		return "internal"
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
 	}
-	err = os.WriteFile(outputFilePath, data, 0644)
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: legacybackupconverter <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Paths may be - to read from stdin or write to stdout.")
+	fmt.Fprintln(w, "Run 'legacybackupconverter help <command>' for the flags of a command.")
+}
+
+// Returns a flag set for the command printing its usage on --help
+func (cmd *command) newFlagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintf(out, "Usage: legacybackupconverter %s [flags] %s\n\n%s\n", cmd.name, cmd.args, cmd.summary)
+
+		var hasFlags bool
+		flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+
+		if hasFlags {
+			fmt.Fprintln(out, "\nFlags:")
+			flags.PrintDefaults()
+		}
+	}
+	return flags
+}
+
+// Parses the flags of a command, requiring between min and max positional arguments
+func (cmd *command) parse(flags *flag.FlagSet, args []string, min, max int) error {
+	err := flags.Parse(args)
+
+	if errors.Is(err, flag.ErrHelp) {
+		return reportedExit(exitOK)
+	}
+
 	if err != nil {
-		panic(err)
+		// The flag package has already printed the error and usage
+		return reportedExit(exitUsage)
+	}
+
+	if flags.NArg() < min {
+		return usageError("missing arguments")
 	}
+
+	if flags.NArg() > max {
+		return usageError("too many arguments: " + strings.Join(flags.Args()[max:], " "))
+	}
+
+	return nil
+}
+
+// Reads a file, or stdin if path is -
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+
+	return os.ReadFile(path)
+}
+
+// Returns true if path is an existing directory
+func isDir(path string) bool {
+	if path == "-" {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// Writes a file, or stdout if path is -
+func writeOutput(path string, data []byte) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+	"github.com/anti-raid/legacybackupconverter/internal/legacytest"
+)
+
+func TestErrorMapping(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		exit  int
+		class string
+	}{
+		{"nil", nil, exitOK, ""},
+		{"usage", usageError("missing argument"), exitUsage, "invalid_request"},
+		{"invalid options", fmt.Errorf("%w: no channels", converter.ErrInvalidOptions), exitUsage, "invalid_request"},
+		{"reported", reportedExit(exitDifferences), exitDifferences, "internal"},
+		{"password required", fmt.Errorf("failed to open: %w", converter.ErrPasswordRequired), exitPassword, "password_required"},
+		{"wrong password", converter.ErrWrongPassword, exitPassword, "wrong_password"},
+		{"invalid backup", fmt.Errorf("%w: no manifest", converter.ErrInvalidBackup), exitInvalidBackup, "invalid_backup"},
+		{"unsupported backup", converter.ErrUnsupportedBackup, exitUnsupportedBackup, "unsupported_backup"},
+		{"integrity", converter.ErrIntegrity, exitIntegrity, "integrity"},
+		{"invalid signature", converter.ErrInvalidSignature, exitIntegrity, "integrity"},
+		{"unsigned", converter.ErrUnsigned, exitIntegrity, "integrity"},
+		{"schema", converter.SchemaErrors{}, exitIntegrity, "integrity"},
+		{"limit exceeded", fmt.Errorf("entry core.json.gz: %w", converter.ErrLimitExceeded), exitLimitExceeded, "limit_exceeded"},
+		{"timeout", fmt.Errorf("%w: %w", converter.ErrCanceled, context.DeadlineExceeded), exitCanceled, "timeout"},
+		{"canceled", fmt.Errorf("%w: %w", converter.ErrCanceled, context.Canceled), exitCanceled, "canceled"},
+		{"io", &fs.PathError{Op: "open", Path: "backup.iblfile", Err: fs.ErrNotExist}, exitIO, "io"},
+		{"other", errors.New("boom"), exitError, "internal"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := exitCode(test.err); got != test.exit {
+				t.Errorf("expected exit code %d, got %d", test.exit, got)
+			}
+			if got := errorClass(test.err); got != test.class {
+				t.Errorf("expected class %q, got %q", test.class, got)
+			}
+		})
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"convert", []string{"--level", "10", "backup.iblfile", "backup.arb1"}},
+		{"convert", []string{"--codec", "zstd", "--level", "23", "backup.iblfile", "backup.arb1"}},
+		{"convert", []string{"--codec", "none", "--level", "1", "backup.iblfile", "backup.arb1"}},
+		{"convert", []string{"--sign-key", "key.pem", "--sig-out", "-", "backup.iblfile", "-"}},
+		{"convert", []string{"--encrypt-password", "secret", "--encrypt-password-file", "password.txt", "backup.iblfile", "backup.arb1"}},
+		{"convert", []string{"--encrypt-password-file", "-", "-", "backup.arb1"}},
+		{"verify", []string{"--sig", "backup.arb1.sig", "backup.arb1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name+" "+strings.Join(test.args, " "), func(t *testing.T) {
+			cmd := findCommand(test.name)
+			err := cmd.run(t.Context(), cmd, test.args)
+			if code := exitCode(err); code != exitUsage {
+				t.Fatalf("expected a usage error, got %v (exit code %d)", err, code)
+			}
+		})
+	}
+}
+
+func TestConvertEncryptPasswordFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "backup.iblfile")
+	out := filepath.Join(dir, "backup.arb1e")
+	passwordFile := filepath.Join(dir, "password.txt")
+
+	err := os.WriteFile(in, legacytest.Backup(t, 3, ""), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(passwordFile, []byte("hunter2\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := findCommand("convert")
+	err = cmd.run(t.Context(), cmd, []string{"-q", "--encrypt-password-file", passwordFile, in, out})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = converter.DecryptBackup(data, "hunter2")
+	if err != nil {
+		t.Fatalf("failed to decrypt with the password from the file: %s", err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"cmp"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+// Conversion options chosen by clients of the serve and worker commands, named like the flags of convert
+type conversionOptions struct {
+	Codec                 string   `json:"codec,omitempty"`
+	Level                 int      `json:"level,omitempty"`
+	Format                string   `json:"format,omitempty"`
+	EnforceAllocations    bool     `json:"enforce_allocations,omitempty"`
+	PruneDanglingOptions  bool     `json:"prune_dangling_options,omitempty"`
+	KeepAttachments       bool     `json:"keep_attachments,omitempty"`
+	DedupAuthors          bool     `json:"dedup_authors,omitempty"`
+	SplitMessages         bool     `json:"split_messages,omitempty"`
+	SkipMessages          bool     `json:"skip_messages,omitempty"`
+	SkipAssets            bool     `json:"skip_assets,omitempty"`
+	Channels              []string `json:"channels,omitempty"`
+	ExcludeChannels       []string `json:"exclude_channels,omitempty"`
+	MaxMessagesPerChannel int      `json:"max_messages_per_channel,omitempty"`
+	EncryptPassword       string   `json:"encrypt_password,omitempty"`
+	Reproducible          bool     `json:"reproducible,omitempty"`
+}
+
+// Reads conversion options from query parameters such as ?codec=zstd&split_messages=true&channels=1,2
+//
+// The encryption password is refused, as queries end up in logs and browser histories
+func queryOptions(query url.Values) (conversionOptions, error) {
+	var opts conversionOptions
+	var err error
+
+	parseBool := func(name string, v *bool) {
+		if s := query.Get(name); s != "" && err == nil {
+			*v, err = strconv.ParseBool(s)
+			if err != nil {
+				err = usageError("invalid value for " + name + ": " + s)
+			}
+		}
+	}
+
+	parseInt := func(name string, v *int) {
+		if s := query.Get(name); s != "" && err == nil {
+			*v, err = strconv.Atoi(s)
+			if err != nil {
+				err = usageError("invalid value for " + name + ": " + s)
+			}
+		}
+	}
+
+	if query.Has("encrypt_password") {
+		return opts, usageError("encrypt_password must be passed in the " + encryptPasswordHeader + " header")
+	}
+
+	opts.Codec = query.Get("codec")
+	opts.Format = query.Get("format")
+	opts.Channels = splitList(query.Get("channels"))
+	opts.ExcludeChannels = splitList(query.Get("exclude_channels"))
+	parseInt("level", &opts.Level)
+	parseInt("max_messages_per_channel", &opts.MaxMessagesPerChannel)
+	parseBool("enforce_allocations", &opts.EnforceAllocations)
+	parseBool("prune_dangling_options", &opts.PruneDanglingOptions)
+	parseBool("keep_attachments", &opts.KeepAttachments)
+	parseBool("dedup_authors", &opts.DedupAuthors)
+	parseBool("split_messages", &opts.SplitMessages)
+	parseBool("skip_messages", &opts.SkipMessages)
+	parseBool("skip_assets", &opts.SkipAssets)
+	parseBool("reproducible", &opts.Reproducible)
+
+	return opts, err
+}
+
+// Returns the ConvertOptions for the chosen options. OutputDir is left to the caller
+func (o conversionOptions) convertOptions() (converter.ConvertOptions, error) {
+	var opts converter.ConvertOptions
+
+	codec, err := converter.ParseCodec(cmp.Or(o.Codec, string(converter.CodecGzip)))
+	if err != nil {
+		return opts, usageError(err.Error())
+	}
+
+	err = codec.ValidateLevel(o.Level)
+	if err != nil {
+		return opts, usageError(err.Error())
+	}
+
+	format, err := converter.ParseOutputFormat(cmp.Or(o.Format, string(converter.OutputTar)))
+	if err != nil {
+		return opts, usageError(err.Error())
+	}
+
+	opts = converter.ConvertOptions{
+		EnforceAllocations:    o.EnforceAllocations,
+		PruneDanglingOptions:  o.PruneDanglingOptions,
+		KeepAttachments:       o.KeepAttachments,
+		DedupAuthors:          o.DedupAuthors,
+		SplitMessages:         o.SplitMessages,
+		Codec:                 codec,
+		CompressionLevel:      o.Level,
+		Format:                format,
+		SkipMessages:          o.SkipMessages,
+		SkipAssets:            o.SkipAssets,
+		IncludeChannels:       o.Channels,
+		ExcludeChannels:       o.ExcludeChannels,
+		MaxMessagesPerChannel: o.MaxMessagesPerChannel,
+		EncryptionPassword:    o.EncryptPassword,
+	}
+	if !o.Reproducible {
+		opts.ConvertedAt = time.Now()
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+const progressBarWidth = 30
+
+// Renders conversion progress as a single line that is redrawn in place
+type progressBar struct {
+	w     io.Writer
+	stage converter.ProgressStage
+	last  time.Time
+	drawn bool
+}
+
+func (b *progressBar) update(p converter.Progress) {
+	now := time.Now()
+
+	// Redraw at most 10 times a second, but always on stage changes and when a stage completes
+	if p.Stage == b.stage && p.Done != p.Total && now.Sub(b.last) < 100*time.Millisecond {
+		return
+	}
+
+	b.stage = p.Stage
+	b.last = now
+
+	// Bytes are a better measure than items where known, as channels and entries vary wildly in size
+	fraction := 1.0
+	switch {
+	case p.TotalBytes > 0:
+		fraction = float64(p.Bytes) / float64(p.TotalBytes)
+	case p.Total > 0:
+		fraction = float64(p.Done) / float64(p.Total)
+	}
+	fraction = min(max(fraction, 0), 1)
+
+	filled := int(fraction * progressBarWidth)
+	line := fmt.Sprintf("%-8s [%s%s] %3.0f%% %d/%d", p.Stage, strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), fraction*100, p.Done, p.Total)
+
+	if p.TotalBytes > 0 {
+		line += fmt.Sprintf(" %s/%s", formatBytes(p.Bytes), formatBytes(p.TotalBytes))
+	} else if p.Bytes > 0 {
+		line += " " + formatBytes(p.Bytes)
+	}
+
+	if p.ChannelID != "" && p.Done != p.Total {
+		line += " channel " + p.ChannelID
+	}
+
+	// Return to the start of the line and clear it before redrawing
+	fmt.Fprintf(b.w, "\r\033[K%s", line)
+	b.drawn = true
+}
+
+// Ends the progress line so that any following output starts on a new line
+func (b *progressBar) finish() {
+	if b.drawn {
+		fmt.Fprintln(b.w)
+		b.drawn = false
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Returns true if f is a terminal (rather than a file or pipe)
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
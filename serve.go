@@ -0,0 +1,773 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+// Header carrying the password of an encrypted upload
+const passwordHeader = "X-Backup-Password"
+
+// Header carrying the password to encrypt the output with (see conversionOptions.EncryptPassword)
+const encryptPasswordHeader = "X-Encrypt-Password"
+
+// Returned when all conversion slots or job slots are taken
+var errBusy = errors.New("server is busy, try again later")
+
+// Limits and settings of the HTTP conversion service
+type serverConfig struct {
+	// Largest accepted upload in bytes
+	MaxUploadSize int64
+
+	// Total size of the uploads held in memory at the same time, whether being read, converted or
+	// queued as a job, and of the outputs kept by finished jobs. Room for an upload (MaxUploadSize
+	// if its length is unknown) is reserved before reading it
+	MaxUploadMemory int64
+
+	// Largest total size the entries of an inspected ARB1 backup may decompress to
+	MaxDecodedSize int64
+
+	// Largest backup (sum of entries) a conversion may produce in bytes, 0 for no limit
+	MaxOutputSize int64
+
+	// Number of conversions and inspections running at the same time
+	MaxConcurrent int
+
+	// Number of jobs that may be queued or running at the same time
+	MaxJobs int
+
+	// Uploads larger than this are converted as a job instead of in the request, 0 to never do so
+	AsyncThreshold int64
+
+	// Maximum duration of a single conversion, 0 for no limit
+	Timeout time.Duration
+
+	// How long finished jobs (and their output) are kept
+	JobTTL time.Duration
+
+	// Number of finished jobs kept, the oldest are dropped before their JobTTL beyond this
+	MaxRetainedJobs int
+
+	// If set, every converted backup is signed with this key
+	SigningKey ed25519.PrivateKey
+}
+
+// The HTTP conversion service, see newServer
+type server struct {
+	ctx    context.Context // Canceled on shutdown, aborting queued and running jobs
+	cfg    serverConfig
+	logger *slog.Logger
+	slots  chan struct{}
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	reserved int64 // Bytes of MaxUploadMemory reserved by uploads and job outputs
+}
+
+type jobStatus string
+
+const (
+	jobPending jobStatus = "pending"
+	jobRunning jobStatus = "running"
+	jobDone    jobStatus = "done"
+	jobFailed  jobStatus = "failed"
+)
+
+// A conversion running in the background. Fields are guarded by server.mu
+type job struct {
+	ID         string                   `json:"id"`
+	Status     jobStatus                `json:"status"`
+	CreatedAt  time.Time                `json:"created_at"`
+	FinishedAt *time.Time               `json:"finished_at,omitempty"`
+	Progress   *converter.Progress      `json:"progress,omitempty"`
+	Result     *converter.ConvertResult `json:"result,omitempty"`
+	Error      string                   `json:"error,omitempty"`
+	ErrorClass string                   `json:"error_class,omitempty"`
+
+	contentType string
+	outputSize  int64 // Bytes of MaxUploadMemory reserved by the output until the job is dropped
+}
+
+// Serves the HTTP conversion API until interrupted
+func runServe(ctx context.Context, cmd *command, args []string) error {
+	fs := cmd.newFlagSet()
+	addr := fs.String("addr", "localhost:8080", "Address to listen on")
+	maxUploadSize := fs.Int64("max-upload-size", 512<<20, "Largest accepted upload in bytes")
+	maxUploadMemory := fs.Int64("max-upload-memory", 2<<30, "Total size of the uploads and job outputs held in memory at the same time, further uploads are refused")
+	maxDecodedSize := fs.Int64("max-decoded-size", 256<<20, "Refuse inspecting ARB1 backups whose entries decompress to more than this many bytes")
+	maxOutputSize := fs.Int64("max-output-size", 0, "Refuse conversions whose entries add up to more than this many bytes (0 for no limit)")
+	maxConcurrent := fs.Int("max-concurrent", runtime.NumCPU(), "Number of conversions and inspections running at the same time")
+	maxJobs := fs.Int("max-jobs", 16, "Number of background jobs that may be queued or running at the same time")
+	asyncThreshold := fs.Int64("async-threshold", 32<<20, "Convert uploads larger than this many bytes as a background job (0 to never do so)")
+	timeout := fs.Duration("timeout", 10*time.Minute, "Abort conversions taking longer than this (0 for no timeout)")
+	jobTTL := fs.Duration("job-ttl", time.Hour, "How long the output of finished jobs is kept")
+	maxRetainedJobs := fs.Int("max-retained-jobs", 64, "Number of finished jobs whose output is kept, the oldest are dropped first")
+	signKeyPath := fs.String("sign-key", "", "Path to a PEM encoded Ed25519 private key to sign every converted backup with")
+	var log logFlags
+	log.register(fs)
+
+	err := cmd.parse(fs, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	logger, err := log.logger(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if *maxConcurrent < 1 || *maxJobs < 1 || *maxRetainedJobs < 1 {
+		return usageError("--max-concurrent, --max-jobs and --max-retained-jobs must be at least 1")
+	}
+
+	if *maxUploadMemory < *maxUploadSize {
+		return usageError("--max-upload-memory must be at least --max-upload-size")
+	}
+
+	cfg := serverConfig{
+		MaxUploadSize:   *maxUploadSize,
+		MaxUploadMemory: *maxUploadMemory,
+		MaxDecodedSize:  *maxDecodedSize,
+		MaxOutputSize:   *maxOutputSize,
+		MaxConcurrent:   *maxConcurrent,
+		MaxJobs:         *maxJobs,
+		AsyncThreshold:  *asyncThreshold,
+		Timeout:         *timeout,
+		JobTTL:          *jobTTL,
+		MaxRetainedJobs: *maxRetainedJobs,
+	}
+
+	if *signKeyPath != "" {
+		keyBytes, err := os.ReadFile(*signKeyPath)
+		if err != nil {
+			return err
+		}
+
+		cfg.SigningKey, err = converter.ParseSigningKey(keyBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           newServer(ctx, cfg, logger),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	logger.Info("listening", "addr", *addr)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stopping the server is the normal way to end it, so this is not reported as a cancellation
+	logger.Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return srv.Shutdown(shutdownCtx)
+}
+
+// Returns the handler of the HTTP conversion API. Jobs are aborted (and finished jobs no longer
+// swept) once ctx is done
+//
+//	GET  /healthz            Liveness and load
+//	POST /convert            Converts an upload, returning the ARB1 backup or (202) a job
+//	POST /inspect            Returns the inspect report of an upload as JSON
+//	GET  /jobs/{id}          Status, progress and result of a job
+//	GET  /jobs/{id}/output   The ARB1 backup of a finished job
+//
+// Uploads are either the raw request body or the "file" field of a multipart form, the
+// password of encrypted uploads is passed in the X-Backup-Password header and the password
+// to encrypt the output with in the X-Encrypt-Password header
+func newServer(ctx context.Context, cfg serverConfig, logger *slog.Logger) http.Handler {
+	s := &server{
+		ctx:    ctx,
+		cfg:    cfg,
+		logger: logger,
+		slots:  make(chan struct{}, cfg.MaxConcurrent),
+		jobs:   make(map[string]*job),
+	}
+
+	go s.sweepJobs()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("POST /convert", s.handleConvert)
+	mux.HandleFunc("POST /inspect", s.handleInspect)
+	mux.HandleFunc("GET /jobs/{id}", s.handleJob)
+	mux.HandleFunc("GET /jobs/{id}/output", s.handleJobOutput)
+
+	return s.logRequests(mux)
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var active int
+	for _, j := range s.jobs {
+		if j.Status == jobPending || j.Status == jobRunning {
+			active++
+		}
+	}
+	reserved := s.reserved
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":            "ok",
+		"running":           len(s.slots),
+		"max_concurrent":    cap(s.slots),
+		"active_jobs":       active,
+		"max_jobs":          s.cfg.MaxJobs,
+		"upload_memory":     reserved,
+		"max_upload_memory": s.cfg.MaxUploadMemory,
+	})
+}
+
+func (s *server) handleConvert(w http.ResponseWriter, r *http.Request) {
+	reqOpts, err := queryOptions(r.URL.Query())
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	reqOpts.EncryptPassword = r.Header.Get(encryptPasswordHeader)
+
+	opts, err := reqOpts.convertOptions()
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	// The output is returned to the client, so it cannot be a directory
+	if opts.Format == converter.OutputDir {
+		s.writeError(w, usageError("the dir output format is not available over HTTP"))
+		return
+	}
+
+	data, unreserve, err := s.readUpload(w, r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	async, _ := strconv.ParseBool(r.URL.Query().Get("async"))
+	if async || (s.cfg.AsyncThreshold > 0 && int64(len(data)) > s.cfg.AsyncThreshold) {
+		j, err := s.startJob(data, unreserve, r.Header.Get(passwordHeader), opts)
+		if err != nil {
+			unreserve()
+			s.writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Location", "/jobs/"+j.ID)
+		writeJSON(w, http.StatusAccepted, map[string]string{
+			"id":         j.ID,
+			"status":     string(jobPending),
+			"status_url": "/jobs/" + j.ID,
+			"output_url": "/jobs/" + j.ID + "/output",
+		})
+		return
+	}
+
+	if !s.tryAcquire() {
+		unreserve()
+		s.writeError(w, errBusy)
+		return
+	}
+
+	ctx, wait := converter.WithBackgroundWait(r.Context())
+	res, err := s.convert(ctx, data, r.Header.Get(passwordHeader), opts, s.logger)
+	s.releaseAfter(err, wait, unreserve)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", outputContentType(opts))
+	w.Header().Set("Content-Length", strconv.Itoa(len(res.Data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(res.Data)
+}
+
+func (s *server) handleInspect(w http.ResponseWriter, r *http.Request) {
+	data, unreserve, err := s.readUpload(w, r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	if !s.tryAcquire() {
+		unreserve()
+		s.writeError(w, errBusy)
+		return
+	}
+
+	ctx, wait := converter.WithBackgroundWait(r.Context())
+	if s.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.Timeout)
+		defer cancel()
+	}
+
+	report, err := inspectData(ctx, data, r.Header.Get(passwordHeader), converter.ReadOptions{MaxDecodedSize: s.cfg.MaxDecodedSize})
+	s.releaseAfter(err, wait, unreserve)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	var status job
+	if ok {
+		status = *j
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown job", Class: "not_found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *server) handleJobOutput(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	var status job
+	if ok {
+		status = *j
+	}
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown job", Class: "not_found"})
+	case status.Status == jobFailed:
+		writeJSON(w, errorStatus(status.ErrorClass), errorResponse{Error: status.Error, Class: status.ErrorClass})
+	case status.Status != jobDone:
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "job is " + string(status.Status), Class: "not_finished"})
+	default:
+		w.Header().Set("Content-Type", status.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(status.Result.Data)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(status.Result.Data)
+	}
+}
+
+// Queues a conversion as a job, failing with errBusy if MaxJobs jobs are already queued or running
+//
+// The job calls unreserve once done with data, unless it could not be queued
+func (s *server) startJob(data []byte, unreserve func(), password string, opts converter.ConvertOptions) (*job, error) {
+	j := &job{
+		ID:          rand.Text(),
+		Status:      jobPending,
+		CreatedAt:   time.Now(),
+		contentType: outputContentType(opts),
+	}
+
+	s.mu.Lock()
+	s.dropJobs()
+
+	var active int
+	for _, other := range s.jobs {
+		if other.Status == jobPending || other.Status == jobRunning {
+			active++
+		}
+	}
+
+	if active >= s.cfg.MaxJobs {
+		s.mu.Unlock()
+		return nil, errBusy
+	}
+
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+
+	logger := s.logger.With("job_id", j.ID)
+	logger.Info("queued job", "size", len(data))
+
+	opts.Progress = func(p converter.Progress) {
+		s.mu.Lock()
+		j.Progress = &p
+		s.mu.Unlock()
+	}
+
+	go func() {
+		var res *converter.ConvertResult
+
+		err := s.acquire(s.ctx)
+		if err == nil {
+			s.mu.Lock()
+			j.Status = jobRunning
+			s.mu.Unlock()
+
+			ctx, wait := converter.WithBackgroundWait(s.ctx)
+			res, err = s.convert(ctx, data, password, opts, logger)
+			s.releaseAfter(err, wait, unreserve)
+		} else {
+			unreserve()
+		}
+
+		// The output is kept in memory until the job is dropped, so it takes the room of an upload
+		if err == nil && !s.reserve(int64(len(res.Data))) {
+			err = fmt.Errorf("%w: no upload memory left to keep the output", errBusy)
+		}
+
+		now := time.Now()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		defer s.dropJobs()
+
+		j.FinishedAt = &now
+		if err != nil {
+			j.Status = jobFailed
+			j.Error = err.Error()
+			j.ErrorClass = serveErrorClass(err)
+			logger.Info("job failed", "error", err, "class", j.ErrorClass)
+			return
+		}
+
+		j.Status = jobDone
+		j.Result = res
+		j.outputSize = int64(len(res.Data))
+		logger.Info("job done", "size", len(res.Data))
+	}()
+
+	return j, nil
+}
+
+// Drops finished jobs older than JobTTL, along with the oldest ones beyond MaxRetainedJobs.
+// s.mu must be held
+func (s *server) dropJobs() {
+	var finished []*job
+	for _, j := range s.jobs {
+		switch {
+		case j.FinishedAt == nil:
+		case time.Since(*j.FinishedAt) > s.cfg.JobTTL:
+			s.dropJob(j)
+		default:
+			finished = append(finished, j)
+		}
+	}
+
+	if len(finished) <= s.cfg.MaxRetainedJobs {
+		return
+	}
+
+	slices.SortFunc(finished, func(a, b *job) int {
+		return a.FinishedAt.Compare(*b.FinishedAt)
+	})
+
+	for _, j := range finished[:len(finished)-s.cfg.MaxRetainedJobs] {
+		s.dropJob(j)
+	}
+}
+
+// Drops a finished job, giving back the room of its output. s.mu must be held
+func (s *server) dropJob(j *job) {
+	delete(s.jobs, j.ID)
+	s.reserved -= j.outputSize
+}
+
+// Drops expired jobs periodically until s.ctx is done, so that their output is not kept
+// until the next job is queued
+func (s *server) sweepJobs() {
+	ticker := time.NewTicker(max(min(s.cfg.JobTTL/2, time.Minute), time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.dropJobs()
+			s.mu.Unlock()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// Converts an upload, logging the warnings of the conversion
+func (s *server) convert(ctx context.Context, data []byte, password string, opts converter.ConvertOptions, logger *slog.Logger) (*converter.ConvertResult, error) {
+	if s.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.Timeout)
+		defer cancel()
+	}
+
+	opts.MaxInputSize = s.cfg.MaxUploadSize
+	opts.MaxOutputSize = s.cfg.MaxOutputSize
+	opts.SigningKey = s.cfg.SigningKey
+	opts.Logger = logger
+
+	res, err := converter.ConvertFileContext(ctx, data, password, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	logWarnings(logger, res.DanglingReferences, res.AllocationIssues)
+	logIntegrityIssues(logger, res.IntegrityIssues)
+
+	return res, nil
+}
+
+// Reads the upload of a request, either the raw body or the "file" field of a multipart form
+//
+// Room for the upload is reserved in MaxUploadMemory before reading it, failing with errBusy if
+// there is not enough. The returned function gives it back once the upload is no longer needed
+func (s *server) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, func(), error) {
+	if r.ContentLength > s.cfg.MaxUploadSize {
+		return nil, nil, fmt.Errorf("%w: upload is larger than %d bytes", converter.ErrLimitExceeded, s.cfg.MaxUploadSize)
+	}
+
+	size := s.cfg.MaxUploadSize
+	if r.ContentLength >= 0 {
+		size = r.ContentLength
+	}
+
+	if !s.reserve(size) {
+		return nil, nil, errBusy
+	}
+
+	data, err := s.readBody(w, r)
+	if err != nil {
+		s.unreserve(size)
+		return nil, nil, err
+	}
+
+	// Multipart framing and a shorter body than announced leave part of the reservation unused
+	s.unreserve(size - int64(len(data)))
+
+	return data, sync.OnceFunc(func() { s.unreserve(int64(len(data))) }), nil
+}
+
+func (s *server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body := http.MaxBytesReader(w, r.Body, s.cfg.MaxUploadSize)
+
+	data, err := func() ([]byte, error) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "multipart/form-data" {
+			return io.ReadAll(body)
+		}
+
+		r.Body = body
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, usageError("invalid multipart upload: " + err.Error())
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, usageError(`multipart upload has no "file" field`)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			if part.FormName() == "file" {
+				return io.ReadAll(part)
+			}
+		}
+	}()
+
+	if maxErr := new(http.MaxBytesError); errors.As(err, &maxErr) {
+		return nil, fmt.Errorf("%w: upload is larger than %d bytes", converter.ErrLimitExceeded, maxErr.Limit)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, usageError("empty upload")
+	}
+
+	return data, nil
+}
+
+// Reserves size bytes of MaxUploadMemory if available
+func (s *server) reserve(size int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reserved+size > s.cfg.MaxUploadMemory {
+		return false
+	}
+
+	s.reserved += size
+	return true
+}
+
+func (s *server) unreserve(size int64) {
+	s.mu.Lock()
+	s.reserved -= size
+	s.mu.Unlock()
+}
+
+// Takes a conversion slot if one is free
+func (s *server) tryAcquire() bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Waits for a conversion slot
+func (s *server) acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", converter.ErrCanceled, context.Cause(ctx))
+	}
+}
+
+func (s *server) release() {
+	<-s.slots
+}
+
+// Releases the conversion slot and the upload (through unreserve) of a conversion that ended with
+// err once wait returns
+//
+// Steps of a canceled conversion may still be running in the background (see
+// converter.WithBackgroundWait) and keep holding the slot and the upload, other conversions
+// release them right away
+func (s *server) releaseAfter(err error, wait func(), unreserve func()) {
+	if !errors.Is(err, converter.ErrCanceled) {
+		wait()
+		s.release()
+		unreserve()
+		return
+	}
+
+	go func() {
+		wait()
+		s.release()
+		unreserve()
+	}()
+}
+
+// The body of error responses
+type errorResponse struct {
+	Error string `json:"error"`
+	Class string `json:"class"` // See errorClass
+}
+
+func (s *server) writeError(w http.ResponseWriter, err error) {
+	class := serveErrorClass(err)
+	if class == "busy" {
+		w.Header().Set("Retry-After", "5")
+	}
+
+	if class == "internal" || class == "io" {
+		s.logger.Error("request failed", "error", err)
+	}
+
+	writeJSON(w, errorStatus(class), errorResponse{Error: err.Error(), Class: class})
+}
+
+// Like errorClass, with the busy class for errBusy
+func serveErrorClass(err error) string {
+	if errors.Is(err, errBusy) {
+		return "busy"
+	}
+
+	return errorClass(err)
+}
+
+// Maps an error class to the HTTP status of its responses
+func errorStatus(class string) int {
+	switch class {
+	case "invalid_request":
+		return http.StatusBadRequest
+	case "password_required":
+		return http.StatusUnauthorized
+	case "wrong_password":
+		return http.StatusForbidden
+	case "invalid_backup", "unsupported_backup", "integrity":
+		return http.StatusUnprocessableEntity
+	case "limit_exceeded":
+		return http.StatusRequestEntityTooLarge
+	case "timeout":
+		return http.StatusGatewayTimeout
+	case "busy", "canceled":
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func outputContentType(opts converter.ConvertOptions) string {
+	switch {
+	case opts.EncryptionPassword != "":
+		return "application/octet-stream"
+	case opts.Format == converter.OutputZip:
+		return "application/zip"
+	default:
+		return "application/x-tar"
+	}
+}
+
+// A ResponseWriter remembering the status code for the request log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (s *server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		s.logger.Debug("request", "method", r.Method, "path", r.URL.Path, "status", rec.status, "duration", time.Since(start))
+	})
+}
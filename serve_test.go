@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+	"github.com/anti-raid/legacybackupconverter/internal/legacytest"
+)
+
+func testServerConfig() serverConfig {
+	return serverConfig{
+		MaxUploadSize:   1 << 20,
+		MaxUploadMemory: 4 << 20,
+		MaxDecodedSize:  converter.DefaultMaxDecodedSize,
+		MaxConcurrent:   2,
+		MaxJobs:         2,
+		JobTTL:          time.Hour,
+		MaxRetainedJobs: 8,
+	}
+}
+
+func serveRequest(t *testing.T, h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// Returns the handler of a server with cfg, shut down at the end of the test
+func newTestServer(t *testing.T, cfg serverConfig) http.Handler {
+	return newServer(t.Context(), cfg, slog.New(slog.DiscardHandler))
+}
+
+// Decodes the error response of rec, failing unless it has the given status and class
+func expectError(t *testing.T, rec *httptest.ResponseRecorder, status int, class string) {
+	t.Helper()
+
+	var resp errorResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("invalid error response %q: %s", rec.Body.String(), err)
+	}
+
+	if rec.Code != status || resp.Class != class {
+		t.Fatalf("expected %d %s, got %d %s (%s)", status, class, rec.Code, resp.Class, resp.Error)
+	}
+}
+
+func multipartUpload(t *testing.T, url string, data []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("comment", "ignored")
+
+	fw, err := mw.CreateFormFile("file", "backup.iblfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, url, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestServeConvert(t *testing.T) {
+	h := newTestServer(t, testServerConfig())
+	legacy := legacytest.Backup(t, 5, "")
+
+	tests := []struct {
+		name  string
+		req   *http.Request
+		codec converter.Codec
+	}{
+		{"raw", httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(legacy)), converter.CodecGzip},
+		{"multipart", multipartUpload(t, "/convert", legacy), converter.CodecGzip},
+		{"options", httptest.NewRequest(http.MethodPost, "/convert?codec=zstd&split_messages=true", bytes.NewReader(legacy)), converter.CodecZstd},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := serveRequest(t, h, test.req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+
+			if ct := rec.Header().Get("Content-Type"); ct != "application/x-tar" {
+				t.Errorf("unexpected content type %s", ct)
+			}
+
+			b, err := converter.OpenBackup(rec.Body.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if b.Codec() != test.codec {
+				t.Errorf("expected codec %s, got %s", test.codec, b.Codec())
+			}
+		})
+	}
+}
+
+func TestServeConvertEncryption(t *testing.T) {
+	h := newTestServer(t, testServerConfig())
+	legacy := legacytest.Backup(t, 5, "")
+
+	rec := serveRequest(t, h, httptest.NewRequest(http.MethodPost, "/convert?encrypt_password=secret", bytes.NewReader(legacy)))
+	expectError(t, rec, http.StatusBadRequest, "invalid_request")
+
+	req := httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(legacy))
+	req.Header.Set(encryptPasswordHeader, "secret")
+
+	rec = serveRequest(t, h, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	data, err := converter.DecryptBackup(rec.Body.Bytes(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = converter.OpenBackup(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServePasswordErrors(t *testing.T) {
+	h := newTestServer(t, testServerConfig())
+	legacy := legacytest.Backup(t, 5, "hunter2")
+
+	tests := []struct {
+		name     string
+		path     string
+		password string
+		status   int
+		class    string
+	}{
+		{"convert without password", "/convert", "", http.StatusUnauthorized, "password_required"},
+		{"convert with wrong password", "/convert", "nope", http.StatusForbidden, "wrong_password"},
+		{"convert", "/convert", "hunter2", http.StatusOK, ""},
+		{"inspect without password", "/inspect", "", http.StatusUnauthorized, "password_required"},
+		{"inspect with wrong password", "/inspect", "nope", http.StatusForbidden, "wrong_password"},
+		{"inspect", "/inspect", "hunter2", http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.path, bytes.NewReader(legacy))
+			if test.password != "" {
+				req.Header.Set(passwordHeader, test.password)
+			}
+
+			rec := serveRequest(t, h, req)
+			if test.class != "" {
+				expectError(t, rec, test.status, test.class)
+			} else if rec.Code != test.status {
+				t.Fatalf("expected %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeLimits(t *testing.T) {
+	legacy := legacytest.Backup(t, 5, "")
+
+	small := testServerConfig()
+	small.MaxUploadSize = 64
+
+	busy := testServerConfig()
+	busy.MaxUploadSize = 1 << 20
+	busy.MaxUploadMemory = 64
+
+	tests := []struct {
+		name   string
+		cfg    serverConfig
+		req    func() *http.Request
+		status int
+		class  string
+	}{
+		{
+			name: "upload too large",
+			cfg:  small,
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(legacy))
+			},
+			status: http.StatusRequestEntityTooLarge,
+			class:  "limit_exceeded",
+		},
+		{
+			name: "upload of unknown length too large",
+			cfg:  small,
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/inspect", io.MultiReader(bytes.NewReader(legacy)))
+				req.ContentLength = -1
+				return req
+			},
+			status: http.StatusRequestEntityTooLarge,
+			class:  "limit_exceeded",
+		},
+		{
+			name: "upload memory exhausted",
+			cfg:  busy,
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(legacy))
+			},
+			status: http.StatusServiceUnavailable,
+			class:  "busy",
+		},
+		{
+			name: "empty upload",
+			cfg:  testServerConfig(),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/convert", nil)
+			},
+			status: http.StatusBadRequest,
+			class:  "invalid_request",
+		},
+		{
+			name: "dir output format",
+			cfg:  testServerConfig(),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/convert?format=dir", bytes.NewReader(legacy))
+			},
+			status: http.StatusBadRequest,
+			class:  "invalid_request",
+		},
+		{
+			name: "invalid level",
+			cfg:  testServerConfig(),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/convert?codec=none&level=3", bytes.NewReader(legacy))
+			},
+			status: http.StatusBadRequest,
+			class:  "invalid_request",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := serveRequest(t, newTestServer(t, test.cfg), test.req())
+			expectError(t, rec, test.status, test.class)
+
+			if test.class == "busy" && rec.Header().Get("Retry-After") == "" {
+				t.Error("expected a Retry-After header")
+			}
+		})
+	}
+}
+
+func TestServeInspect(t *testing.T) {
+	legacy := legacytest.Backup(t, 5, "")
+
+	res, err := converter.ConvertFileWithOptions(legacy, "", converter.ConvertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bounded := testServerConfig()
+	bounded.MaxDecodedSize = 16
+
+	tests := []struct {
+		name     string
+		cfg      serverConfig
+		data     []byte
+		kind     string
+		channels int
+	}{
+		{"legacy", testServerConfig(), legacy, "legacy", 3},
+		{"converted", testServerConfig(), res.Data, "arb1", 3},
+		{"decoded size exceeded", bounded, res.Data, "", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := serveRequest(t, newTestServer(t, test.cfg), httptest.NewRequest(http.MethodPost, "/inspect", bytes.NewReader(test.data)))
+			if test.kind == "" {
+				expectError(t, rec, http.StatusRequestEntityTooLarge, "limit_exceeded")
+				return
+			}
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var report inspectReport
+			err := json.Unmarshal(rec.Body.Bytes(), &report)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Kind != test.kind || report.Guild.ID != "1" || len(report.Channels) != test.channels {
+				t.Fatalf("unexpected report %+v", report)
+			}
+		})
+	}
+}
+
+func TestServeHealth(t *testing.T) {
+	cfg := testServerConfig()
+	rec := serveRequest(t, newTestServer(t, cfg), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var health struct {
+		Status          string `json:"status"`
+		Running         int    `json:"running"`
+		MaxConcurrent   int    `json:"max_concurrent"`
+		MaxJobs         int    `json:"max_jobs"`
+		UploadMemory    int64  `json:"upload_memory"`
+		MaxUploadMemory int64  `json:"max_upload_memory"`
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), &health)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if health.Status != "ok" || health.Running != 0 || health.MaxConcurrent != cfg.MaxConcurrent || health.MaxJobs != cfg.MaxJobs ||
+		health.UploadMemory != 0 || health.MaxUploadMemory != cfg.MaxUploadMemory {
+		t.Fatalf("unexpected health %s", rec.Body.String())
+	}
+}
+
+// Polls a job until it is finished, returning its status
+func waitForJob(t *testing.T, h http.Handler, id string) job {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		rec := serveRequest(t, h, httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var status job
+		err := json.Unmarshal(rec.Body.Bytes(), &status)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status.Status == jobDone || status.Status == jobFailed {
+			return status
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s did not finish", id)
+	return job{}
+}
+
+// Queues a conversion as a job, returning its ID
+func startTestJob(t *testing.T, h http.Handler, data []byte, password string) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/convert?async=true", bytes.NewReader(data))
+	if password != "" {
+		req.Header.Set(passwordHeader, password)
+	}
+
+	rec := serveRequest(t, h, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var accepted map[string]string
+	err := json.Unmarshal(rec.Body.Bytes(), &accepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Header().Get("Location") != accepted["status_url"] || accepted["output_url"] != "/jobs/"+accepted["id"]+"/output" {
+		t.Fatalf("unexpected response %s", rec.Body.String())
+	}
+
+	return accepted["id"]
+}
+
+func TestServeJobs(t *testing.T) {
+	cfg := testServerConfig()
+	cfg.AsyncThreshold = 1
+	h := newTestServer(t, cfg)
+
+	legacy := legacytest.Backup(t, 5, "hunter2")
+
+	t.Run("done", func(t *testing.T) {
+		id := startTestJob(t, h, legacy, "hunter2")
+
+		status := waitForJob(t, h, id)
+		if status.Status != jobDone || status.Result == nil || status.Progress == nil || status.FinishedAt == nil {
+			t.Fatalf("unexpected status %+v", status)
+		}
+
+		rec := serveRequest(t, h, httptest.NewRequest(http.MethodGet, "/jobs/"+id+"/output", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		_, err := converter.OpenBackup(rec.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		id := startTestJob(t, h, legacy, "nope")
+
+		status := waitForJob(t, h, id)
+		if status.Status != jobFailed || status.ErrorClass != "wrong_password" {
+			t.Fatalf("unexpected status %+v", status)
+		}
+
+		rec := serveRequest(t, h, httptest.NewRequest(http.MethodGet, "/jobs/"+id+"/output", nil))
+		expectError(t, rec, http.StatusForbidden, "wrong_password")
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		expectError(t, serveRequest(t, h, httptest.NewRequest(http.MethodGet, "/jobs/nope", nil)), http.StatusNotFound, "not_found")
+		expectError(t, serveRequest(t, h, httptest.NewRequest(http.MethodGet, "/jobs/nope/output", nil)), http.StatusNotFound, "not_found")
+	})
+}
+
+func TestServeRetainedJobs(t *testing.T) {
+	cfg := testServerConfig()
+	cfg.MaxRetainedJobs = 1
+	h := newTestServer(t, cfg)
+
+	legacy := legacytest.Backup(t, 5, "")
+
+	first := startTestJob(t, h, legacy, "")
+	waitForJob(t, h, first)
+
+	second := startTestJob(t, h, legacy, "")
+	waitForJob(t, h, second)
+
+	expectError(t, serveRequest(t, h, httptest.NewRequest(http.MethodGet, "/jobs/"+first, nil)), http.StatusNotFound, "not_found")
+
+	output := serveRequest(t, h, httptest.NewRequest(http.MethodGet, "/jobs/"+second+"/output", nil))
+	if output.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", output.Code, output.Body.String())
+	}
+
+	// Only the output of the retained job is still held, the uploads and the dropped output are released
+	rec := serveRequest(t, h, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	var health struct {
+		UploadMemory int `json:"upload_memory"`
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), &health)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if health.UploadMemory != output.Body.Len() {
+		t.Fatalf("expected an upload memory of %d bytes, got %s", output.Body.Len(), rec.Body.String())
+	}
+}
+
+func TestServeRetainedOutputsTakeUploadMemory(t *testing.T) {
+	legacy := legacytest.Backup(t, 5, "")
+
+	cfg := testServerConfig()
+	cfg.MaxUploadMemory = int64(len(legacy)) + 1024
+	h := newTestServer(t, cfg)
+
+	id := startTestJob(t, h, legacy, "")
+	if status := waitForJob(t, h, id); status.Status != jobDone {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// The output of the finished job leaves no room for another upload of the same size
+	rec := serveRequest(t, h, httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(legacy)))
+	expectError(t, rec, http.StatusServiceUnavailable, "busy")
+}
+
+func TestServeReleasesCanceledConversionsAfterWait(t *testing.T) {
+	s := &server{cfg: testServerConfig(), slots: make(chan struct{}, 1)}
+
+	if !s.tryAcquire() || !s.reserve(100) {
+		t.Fatal("failed to take a slot and reserve the upload")
+	}
+
+	done := make(chan struct{})
+	released := make(chan struct{})
+	s.releaseAfter(converter.ErrCanceled, func() { <-done }, func() {
+		s.unreserve(100)
+		close(released)
+	})
+
+	// The background steps of the canceled conversion still hold the upload
+	s.mu.Lock()
+	reserved := s.reserved
+	s.mu.Unlock()
+	if reserved != 100 || s.tryAcquire() {
+		t.Fatalf("expected the slot and upload to be held until wait returns, %d bytes reserved", reserved)
+	}
+
+	close(done)
+	<-released
+
+	if !s.tryAcquire() {
+		t.Fatal("expected the slot to be released once wait returned")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+// Checks that a converted backup can be read, matches its checksums and matches the published schema
+//
+// If a public key is given, the backup must also carry a valid signature made with the matching private key
+func runVerify(ctx context.Context, cmd *command, args []string) error {
+	fs := cmd.newFlagSet()
+	pubKeyPath := fs.String("pubkey", "", "Path to a PEM encoded Ed25519 public key the backup must be signed with")
+	sigPath := fs.String("sig", "", "Path to a detached signature to use instead of the one embedded in the backup")
+	password := fs.String("password", "", "Password of an encrypted (.arb1e) backup")
+	var log logFlags
+	log.register(fs)
+
+	err := cmd.parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	if *sigPath != "" && *pubKeyPath == "" {
+		return usageError("--sig requires --pubkey")
+	}
+
+	logger, err := log.logger(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	backup, err := openConverted(fs.Arg(0), *password)
+	if err != nil {
+		return err
+	}
+
+	// OpenBackup checks the checksums of any backup with a manifest, so only format version 0 backups lack them
+	if len(backup.Manifest.Entries) == 0 {
+		return fmt.Errorf("%w: backup has no checksums, its integrity cannot be verified", converter.ErrIntegrity)
+	}
+
+	if *pubKeyPath != "" {
+		keyBytes, err := os.ReadFile(*pubKeyPath)
+		if err != nil {
+			return err
+		}
+
+		pub, err := converter.ParsePublicKey(keyBytes)
+		if err != nil {
+			return err
+		}
+
+		var sig []byte
+		if *sigPath != "" {
+			sig, err = os.ReadFile(*sigPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = backup.VerifySignature(pub, sig)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = backup.ValidateSchema()
+	if err != nil {
+		return err
+	}
+
+	logWarnings(logger, converter.CheckOptionReferences(backup.Core), converter.CheckAllocations(backup.Core))
+	logIntegrityIssues(logger, converter.CheckIntegrity(backup.Core))
+
+	fmt.Printf("OK (format version %d, produced by %s %s)\n", backup.Manifest.FormatVersion, backup.Manifest.Producer.Name, backup.Manifest.Producer.Version)
+
+	if source := backup.Manifest.Source; source != nil {
+		fmt.Printf("Backup taken on %s (%s %s, format %s)\n", source.CreatedAt.Format(time.RFC1123), source.Type, source.Protocol, source.FormatVersion)
+	}
+
+	if backup.Manifest.ConvertedAt != nil {
+		fmt.Printf("Migrated on %s\n", backup.Manifest.ConvertedAt.Format(time.RFC1123))
+	}
+
+	return nil
+}
+
+// Opens a converted backup, which is either a tar/zip file (or - for stdin) or a directory written with --format dir
+//
+// If password is not empty, the file is decrypted first
+func openConverted(path, password string) (*converter.Backup, error) {
+	if isDir(path) {
+		return converter.OpenBackupDir(path)
+	}
+
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if password != "" {
+		data, err = converter.DecryptBackup(data, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return converter.OpenBackup(data)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+// Logs a warning per dangling option reference and allocation issue
+func logWarnings(logger *slog.Logger, refs []converter.DanglingReference, issues []converter.AllocationIssue) {
+	for _, ref := range refs {
+		logger.Warn("dangling option reference", "option", ref.Option, "channel_id", ref.ChannelID, "pruned", ref.Pruned)
+	}
+
+	for _, issue := range issues {
+		args := []any{"kind", issue.Kind, "expected", issue.Expected, "actual", issue.Actual}
+		if issue.ChannelID != "" {
+			args = append(args, "channel_id", issue.ChannelID)
+		}
+
+		logger.Warn("allocation issue", args...)
+	}
+}
+
+// Logs a one line summary per class of broken reference
+func logIntegrityIssues(logger *slog.Logger, issues []converter.IntegrityIssue) {
+	summary := converter.SummarizeIntegrity(issues)
+
+	var kinds []converter.IntegrityIssueKind
+	for kind := range summary {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+
+	for _, kind := range kinds {
+		idx := slices.IndexFunc(issues, func(i converter.IntegrityIssue) bool { return i.Kind == kind })
+		logger.Warn("broken references", "kind", kind, "count", summary[kind], "example_id", issues[idx].ID, "example_target", issues[idx].Target)
+	}
+}
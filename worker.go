@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/anti-raid/legacybackupconverter/converter"
+)
+
+// Longest accepted request line, which may hold a base64 encoded backup
+const maxWorkerRequestSize = 1 << 30
+
+// A request read from stdin, one per line
+type workerRequest struct {
+	// Chosen by the client and echoed in every response to the request. Must be unique among running requests
+	ID json.RawMessage `json:"id"`
+
+	// convert, inspect or cancel
+	Method string `json:"method"`
+
+	// The backup to convert or inspect, either as a path or base64 encoded
+	Input     string `json:"input,omitempty"`
+	InputData []byte `json:"input_base64,omitempty"`
+
+	Password string `json:"password,omitempty"`
+
+	// Path to write the converted backup to (a directory with the dir format). If empty, it is
+	// returned base64 encoded in the result
+	Output string `json:"output,omitempty"`
+
+	Options conversionOptions `json:"options"`
+
+	// Send progress events while converting
+	Progress bool `json:"progress,omitempty"`
+
+	// ID of the request to cancel (cancel only)
+	Target json.RawMessage `json:"target,omitempty"`
+}
+
+// A response written to stdout, one per line. Every request gets exactly one result or error
+// event, possibly preceded by progress events
+type workerResponse struct {
+	ID       json.RawMessage     `json:"id"`
+	Event    string              `json:"event"` // progress, result or error
+	Progress *converter.Progress `json:"progress,omitempty"`
+	Result   any                 `json:"result,omitempty"`
+	Error    string              `json:"error,omitempty"`
+	Class    string              `json:"class,omitempty"` // See errorClass
+}
+
+// The result of a convert request
+type workerConvertResult struct {
+	*converter.ConvertResult
+	Output    string `json:"output,omitempty"`
+	Data      []byte `json:"data,omitempty"` // Base64 encoded
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// Serves JSON requests read line by line from stdin until it is closed or interrupted
+type worker struct {
+	ctx     context.Context
+	logger  *slog.Logger
+	timeout time.Duration
+	slots   chan struct{}
+
+	// Serializes responses, as requests run concurrently
+	outMu sync.Mutex
+	out   *json.Encoder
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// Runs the worker protocol on stdin and stdout
+func runWorker(ctx context.Context, cmd *command, args []string) error {
+	fs := cmd.newFlagSet()
+	maxConcurrent := fs.Int("max-concurrent", runtime.NumCPU(), "Number of requests handled at the same time, further requests wait")
+	timeout := fs.Duration("timeout", 0, "Abort requests taking longer than this (0 for no timeout)")
+	var log logFlags
+	log.register(fs)
+
+	err := cmd.parse(fs, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	logger, err := log.logger(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if *maxConcurrent < 1 {
+		return usageError("--max-concurrent must be at least 1")
+	}
+
+	w := &worker{
+		ctx:     ctx,
+		logger:  logger,
+		timeout: *timeout,
+		slots:   make(chan struct{}, *maxConcurrent),
+		out:     json.NewEncoder(os.Stdout),
+		running: make(map[string]context.CancelFunc),
+	}
+
+	return w.serve(os.Stdin)
+}
+
+// Handles requests from r until EOF or interruption, then waits for the running requests to finish
+func (w *worker) serve(r io.Reader) error {
+	lines := make(chan []byte)
+	errs := make(chan error, 1)
+
+	// Reading blocks until the next line, so it happens in the background to notice interrupts
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64<<10), maxWorkerRequestSize)
+
+		for scanner.Scan() {
+			select {
+			case lines <- bytes.Clone(scanner.Bytes()):
+			case <-w.ctx.Done():
+				return
+			}
+		}
+
+		errs <- scanner.Err()
+		close(lines)
+	}()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				w.wg.Wait()
+				return <-errs
+			}
+
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			var req workerRequest
+			err := json.Unmarshal(line, &req)
+			if err != nil {
+				w.sendError(req.ID, usageError("invalid request: "+err.Error()))
+				continue
+			}
+
+			w.handle(req)
+		case <-w.ctx.Done():
+			// Running requests are canceled along with ctx, and still answered with an error
+			w.wg.Wait()
+			return nil
+		}
+	}
+}
+
+func (w *worker) handle(req workerRequest) {
+	if len(req.ID) == 0 || string(req.ID) == "null" {
+		w.sendError(req.ID, usageError("request has no id"))
+		return
+	}
+
+	switch req.Method {
+	case "cancel":
+		w.mu.Lock()
+		cancel, ok := w.running[string(req.Target)]
+		w.mu.Unlock()
+
+		if !ok {
+			w.sendError(req.ID, usageError("no running request with id "+string(req.Target)))
+			return
+		}
+
+		cancel()
+		w.send(workerResponse{ID: req.ID, Event: "result", Result: map[string]bool{"canceled": true}})
+		return
+	case "convert", "inspect":
+	default:
+		w.sendError(req.ID, usageError("unknown method "+req.Method))
+		return
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if w.timeout > 0 {
+		ctx, cancel = context.WithTimeout(w.ctx, w.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(w.ctx)
+	}
+
+	w.mu.Lock()
+	if _, ok := w.running[string(req.ID)]; ok {
+		w.mu.Unlock()
+		cancel()
+		w.sendError(req.ID, usageError("a request with id "+string(req.ID)+" is already running"))
+		return
+	}
+	w.running[string(req.ID)] = cancel
+	w.mu.Unlock()
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() {
+			w.mu.Lock()
+			delete(w.running, string(req.ID))
+			w.mu.Unlock()
+			cancel()
+		}()
+
+		result, err := w.run(ctx, req)
+		if err != nil {
+			w.sendError(req.ID, err)
+			return
+		}
+
+		w.send(workerResponse{ID: req.ID, Event: "result", Result: result})
+	}()
+}
+
+// Runs a convert or inspect request once a slot is free
+func (w *worker) run(ctx context.Context, req workerRequest) (any, error) {
+	select {
+	case w.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", converter.ErrCanceled, context.Cause(ctx))
+	}
+
+	// The slot is held until steps of a canceled request running in the background return
+	ctx, wait := converter.WithBackgroundWait(ctx)
+	defer func() {
+		go func() {
+			wait()
+			<-w.slots
+		}()
+	}()
+
+	logger := w.logger.With("request_id", string(req.ID))
+
+	if req.Method == "inspect" && req.Input != "" && isDir(req.Input) {
+		backup, err := converter.OpenBackupDir(req.Input)
+		if err != nil {
+			return nil, err
+		}
+
+		return convertedReport(backup), nil
+	}
+
+	data := req.InputData
+	if req.Input != "" {
+		var err error
+		data, err = os.ReadFile(req.Input)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) == 0 {
+		return nil, usageError("request has no input or input_base64")
+	}
+
+	if req.Method == "inspect" {
+		return inspectData(ctx, data, req.Password, converter.ReadOptions{})
+	}
+
+	opts, err := req.Options.convertOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Format == converter.OutputDir {
+		if req.Output == "" {
+			return nil, usageError("the dir output format requires an output path")
+		}
+
+		opts.OutputDir = req.Output
+	}
+
+	opts.Logger = logger
+	if req.Progress {
+		opts.Progress = func(p converter.Progress) {
+			w.send(workerResponse{ID: req.ID, Event: "progress", Progress: &p})
+		}
+	}
+
+	res, err := converter.ConvertFileContext(ctx, data, req.Password, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	logWarnings(logger, res.DanglingReferences, res.AllocationIssues)
+	logIntegrityIssues(logger, res.IntegrityIssues)
+
+	result := workerConvertResult{ConvertResult: res, Output: req.Output, Signature: res.Signature}
+
+	switch {
+	case opts.Format == converter.OutputDir:
+	case req.Output != "":
+		err = os.WriteFile(req.Output, res.Data, 0644)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		result.Data = res.Data
+	}
+
+	return result, nil
+}
+
+func (w *worker) send(resp workerResponse) {
+	w.outMu.Lock()
+	defer w.outMu.Unlock()
+
+	err := w.out.Encode(resp)
+	if err != nil {
+		w.logger.Error("failed to write response", "error", err)
+	}
+}
+
+func (w *worker) sendError(id json.RawMessage, err error) {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	w.send(workerResponse{ID: id, Event: "error", Error: err.Error(), Class: errorClass(err)})
+}